@@ -0,0 +1,261 @@
+package goaeoas
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// AssetsPath is where the scripts and styles of the browsable API are served.
+	AssetsPath = "/_goaeoas"
+)
+
+var (
+	contentSecurityPolicy = DefaultContentSecurityPolicy
+)
+
+// DefaultContentSecurityPolicy only allows scripts carrying the nonce of the response, and
+// scripts loaded by them.
+// It allows 'unsafe-eval' since the JSON form library compiles its templates at runtime,
+// use SetContentSecurityPolicy with a stricter policy if the JSON forms aren't needed.
+func DefaultContentSecurityPolicy(nonce string) string {
+	return fmt.Sprintf("default-src 'self'; script-src 'nonce-%s' 'strict-dynamic' 'unsafe-eval'; style-src 'self' 'nonce-%s'; img-src 'self' data:; object-src 'none'; base-uri 'none'; frame-ancestors 'none'", nonce, nonce)
+}
+
+// SetContentSecurityPolicy replaces the function generating the Content-Security-Policy header of HTML responses.
+// The function gets the nonce of the response, and nil disables the header.
+func SetContentSecurityPolicy(f func(nonce string) string) {
+	contentSecurityPolicy = f
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+type asset struct {
+	contentType string
+	content     func() string
+}
+
+var assets = map[string]asset{
+	"browse.js": {
+		contentType: "application/javascript; charset=UTF-8",
+		content:     browseJS,
+	},
+	"browse.css": {
+		contentType: "text/css; charset=UTF-8",
+		content:     browseCSS,
+	},
+	"jsonform.js": {
+		contentType: "application/javascript; charset=UTF-8",
+		content:     jsonformJS,
+	},
+	"jsv.js": {
+		contentType: "application/javascript; charset=UTF-8",
+		content:     jsvJS,
+	},
+}
+
+func assetURL(name string) string {
+	return fmt.Sprintf("%s/%s", AssetsPath, name)
+}
+
+func registerAssets(ro *mux.Router) {
+	for name, a := range assets {
+		content := []byte(a.content())
+		h := sha1.New()
+		h.Write(content)
+		etag := fmt.Sprintf("\"%x\"", h.Sum(nil))
+		contentType := a.contentType
+		ro.Path(assetURL(name)).Methods("GET").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.Write(content)
+		})
+	}
+}
+
+func browseJS() string {
+	return `
+(function() {
+	// The JSON form library interpolates titles and names into its templates without escaping.
+	var safeKey = /^[A-Za-z0-9_.\-]+$/;
+	function sanitize(schema) {
+		if (schema === null || typeof schema !== "object") {
+			return true;
+		}
+		// The JSON form library only understands single types.
+		if (Array.isArray(schema.type)) {
+			schema.type = schema.type[0];
+		} else if (schema.type === undefined && schema.properties === undefined) {
+			schema.type = "string";
+		}
+		if (typeof schema.title === "string") {
+			schema.title = _.escape(schema.title);
+		}
+		for (var key in schema.properties || {}) {
+			// Nor unions, which are left out of the form.
+			if (schema.properties[key] && schema.properties[key].oneOf) {
+				delete schema.properties[key];
+				continue;
+			}
+			if (!safeKey.test(key) || !sanitize(schema.properties[key])) {
+				return false;
+			}
+		}
+		return sanitize(schema.items) && sanitize(schema.additionalProperties);
+	}
+	function showResult(html) {
+		// Rendered in a sandbox without scripts, so the response can't act on this page.
+		var frame = document.createElement("iframe");
+		frame.setAttribute("sandbox", "");
+		frame.className = "result";
+		frame.srcdoc = html;
+		var section = $('<section><header>Result</header><article></article></section>');
+		section.find('article').append(frame);
+		$('body').append(section);
+	}
+	function send(method, url, body, render, data) {
+		var req = new XMLHttpRequest();
+		req.addEventListener("readystatechange", function(ev) {
+			if (req.readyState == 4) {
+				if (req.status > 199 && req.status < 300) {
+					if (render) {
+						showResult(req.responseText);
+					}
+					alert("done");
+				} else {
+					alert(req.responseText);
+				}
+			}
+		});
+		req.open(method, url);
+		req.setRequestHeader("Content-Type", "application/json; charset=utf-8");
+		if (data.csrfHeader && data.csrfToken) {
+			req.setRequestHeader(data.csrfHeader, data.csrfToken);
+		}
+		req.send(body);
+	}
+	document.addEventListener("DOMContentLoaded", function() {
+		$('[data-goaeoas-form]').each(function(idx, el) {
+			var data = el.dataset;
+			var schema = JSON.parse(document.getElementById(data.schema).textContent);
+			if (!sanitize(schema)) {
+				return;
+			}
+			var form = $('<form></form>');
+			$(el).append(form);
+			try {
+				form.jsonForm({
+					schema: schema,
+					form: [
+						"*",
+						{
+							"type": "submit",
+							"title": _.escape(data.rel)
+						}
+					],
+					onSubmitValid: function(values) {
+						send(data.method, data.url, JSON.stringify(values), data.render === "true", data);
+						return false;
+					}
+				});
+			} catch (e) {
+				// Most likely a Content-Security-Policy without 'unsafe-eval', keep the native form.
+				form.remove();
+				return;
+			}
+			$(el).find('form.native').hide();
+		});
+		$('[data-goaeoas-button]').each(function(idx, el) {
+			var data = el.dataset;
+			$(el).find('button').on('click', function() {
+				send(data.method, data.url, null, data.render === "true", data);
+			});
+		});
+	});
+})();
+`
+}
+
+func browseCSS() string {
+	return `
+nav > form {
+	padding: 5pt;
+	margin: 0pt;
+	border-style: inset;
+}
+section {
+	border-style: outset;
+	padding: 5pt;
+	margin: 5pt;
+}
+section > header {
+	font-weight: bold;
+}
+section > article {
+	border-style: inset;
+	padding: 5pt;
+	margin: 5pt;
+}
+section > article > header {
+	font-weight: bold;
+}
+nav {
+	padding: 5pt;
+	margin: 5pt;
+}
+nav > a {
+	margin: 5pt;
+}
+table.properties {
+	border-collapse: collapse;
+}
+table.properties > tbody > tr > th {
+	text-align: left;
+	vertical-align: top;
+	padding-right: 5pt;
+}
+table.properties > tbody > tr > td, dl > dd {
+	padding-bottom: 2pt;
+}
+dl > dt {
+	font-style: italic;
+}
+span.null {
+	color: gray;
+}
+form.native > label {
+	display: block;
+	margin: 2pt;
+}
+form.native span.error {
+	color: red;
+	margin-left: 5pt;
+}
+iframe.result {
+	width: 100%;
+	min-height: 20em;
+	border: none;
+}
+fieldset.control-group {
+	border: 4px outset;
+	padding: 5pt;
+	margin: 5pt;
+}
+`
+}
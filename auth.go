@@ -0,0 +1,495 @@
+package goaeoas
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	BearerScheme = "Bearer"
+	BasicScheme  = "Basic"
+	APIKeyScheme = "APIKey"
+	CookieScheme = "Cookie"
+)
+
+var (
+	authenticators []Authenticator
+	// PrincipalKey holds the Principal of authenticated requests.
+	PrincipalKey = NewKey[*Principal]("goaeoas.principal")
+	// targetKey holds what the Load handler returned when checking the policy of a route.
+	targetKey = NewKey[Itemer]("goaeoas.target")
+)
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// ID identifies the caller, like the subject of a JWT or the username of HTTP Basic auth.
+	ID string
+	// Scheme is the scheme of the Authenticator that authenticated the caller.
+	Scheme string
+	// Claims are scheme specific details about the caller, like the claims of a JWT.
+	Claims map[string]interface{}
+}
+
+// Authenticator finds the Principal of requests using one authentication scheme.
+type Authenticator interface {
+	// Scheme names the authentication scheme.
+	Scheme() string
+	// Authenticate returns the Principal of r, nil if r has no credentials for this
+	// Authenticator, or an error if r has credentials that aren't valid.
+	Authenticate(r Request) (*Principal, error)
+	// Challenge returns the WWW-Authenticate header value for unauthenticated requests,
+	// or "" if the scheme has none. err is the error returned by Authenticate, if any.
+	Challenge(err error) string
+}
+
+// AuthRequirement describes the authentication a route requires.
+type AuthRequirement struct {
+	// Required makes requests without a Principal fail with 401.
+	Required bool
+	// Schemes limits the Authenticators tried for the route, all are tried if empty.
+	Schemes []string
+}
+
+func (a AuthRequirement) accepts(scheme string) bool {
+	if len(a.Schemes) == 0 {
+		return true
+	}
+	for _, accepted := range a.Schemes {
+		if accepted == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAuthenticators replaces the Authenticators tried, in order, before the filters of each request.
+// The first Authenticator finding a Principal wins, and the Principal is stored using PrincipalKey.
+func SetAuthenticators(auths ...Authenticator) {
+	authenticators = auths
+}
+
+// authenticate finds the Principal of r and enforces the AuthRequirement of its route.
+// Invalid credentials don't stop the remaining Authenticators from being tried, and only fail
+// routes not requiring a Principal if they were the only credentials of r. Cookies never fail
+// those routes, since browsers keep sending them after they become invalid.
+func authenticate(w ResponseWriter, r *request, req AuthRequirement) error {
+	var failed Authenticator
+	var failure error
+	failures := 0
+	for _, auth := range authenticators {
+		if !req.accepts(auth.Scheme()) {
+			continue
+		}
+		principal, err := auth.Authenticate(r)
+		if err != nil {
+			if failed == nil {
+				failed, failure = auth, err
+			}
+			failures++
+			continue
+		}
+		if principal != nil {
+			principal.Scheme = auth.Scheme()
+			PrincipalKey.Set(r, principal)
+			return nil
+		}
+	}
+	if req.Required || (failures == 1 && failed.Scheme() != CookieScheme) {
+		return unauthorized(w, req, failed, failure)
+	}
+	return nil
+}
+
+// authorize checks the Policy of the route of r, if any, and returns a 403 error if it denies r.
+func authorize(w ResponseWriter, r Request, opts *routeOptions) error {
+	if opts.policy == nil {
+		return nil
+	}
+	var target *Item
+	if opts.loadTarget != nil {
+		loaded, err := opts.loadTarget(w, r)
+		if err != nil {
+			return err
+		}
+		if loaded != nil {
+			targetKey.Set(r, loaded)
+			target = loaded.Item(r)
+		}
+	}
+	allowed, err := opts.policy(r, target)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return HTTPErr{Body: "forbidden", Status: http.StatusForbidden}
+	}
+	return nil
+}
+
+// linkAllowed returns whether the Policy, if any, of the route of l allows the request that created l to follow it from target.
+func linkAllowed(l *Link, target *Item) bool {
+	if l.req == nil || l.Route == "" {
+		return true
+	}
+	opts, found := routeOpts[l.Route]
+	if !found || opts.policy == nil {
+		return true
+	}
+	allowed, err := opts.policy(l.req, target)
+	if err != nil {
+		logMsg(l.Context(), LogWarn, "leaving out link", "rel", l.Rel, "route", l.Route, "error", err)
+		return false
+	}
+	return allowed
+}
+
+// unauthorized adds the challenges of the Authenticators accepted by req to w, and returns
+// a 401 error. failed is the Authenticator that returned err, if any.
+func unauthorized(w ResponseWriter, req AuthRequirement, failed Authenticator, err error) error {
+	for _, auth := range authenticators {
+		if !req.accepts(auth.Scheme()) {
+			continue
+		}
+		var challenge string
+		if auth == failed {
+			challenge = auth.Challenge(err)
+		} else {
+			challenge = auth.Challenge(nil)
+		}
+		if challenge != "" {
+			w.Header().Add("WWW-Authenticate", challenge)
+		}
+	}
+	if err == nil {
+		return HTTPErr{Body: "authentication required", Status: http.StatusUnauthorized}
+	}
+	return HTTPErr{Body: err.Error(), Status: http.StatusUnauthorized}
+}
+
+// challenge formats a WWW-Authenticate challenge for scheme with params, given as name, value pairs.
+func challenge(scheme string, params ...string) string {
+	parts := []string{}
+	for i := 0; i+1 < len(params); i += 2 {
+		if params[i+1] != "" {
+			parts = append(parts, fmt.Sprintf("%s=%q", params[i], params[i+1]))
+		}
+	}
+	if len(parts) == 0 {
+		return scheme
+	}
+	return scheme + " " + strings.Join(parts, ", ")
+}
+
+// JWTAuthenticator authenticates requests with JSON Web Tokens in "Authorization: Bearer" headers.
+// The subject of the token becomes the ID of the Principal, and the claims its Claims.
+type JWTAuthenticator struct {
+	// HMACKey verifies HS256, HS384 and HS512 tokens.
+	HMACKey []byte
+	// RSAKey verifies RS256, RS384 and RS512 tokens.
+	RSAKey *rsa.PublicKey
+	// Issuer and Audience, if set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+	// Leeway is the clock skew allowed when checking the exp and nbf claims.
+	Leeway time.Duration
+	Realm  string
+}
+
+func (j *JWTAuthenticator) Scheme() string {
+	return BearerScheme
+}
+
+func (j *JWTAuthenticator) Challenge(err error) string {
+	if err == nil {
+		return challenge(BearerScheme, "realm", j.Realm)
+	}
+	return challenge(BearerScheme, "realm", j.Realm, "error", "invalid_token", "error_description", err.Error())
+}
+
+func (j *JWTAuthenticator) Authenticate(r Request) (*Principal, error) {
+	token, found := bearerToken(r.Req())
+	if !found {
+		return nil, nil
+	}
+	claims, err := j.verify(token)
+	if err != nil {
+		return nil, err
+	}
+	sub, _ := claims["sub"].(string)
+	return &Principal{
+		ID:     sub,
+		Claims: claims,
+	}, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], BearerScheme) {
+		return "", false
+	}
+	return strings.TrimSpace(parts[1]), true
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+var hmacHashes = map[crypto.Hash]func() hash.Hash{
+	crypto.SHA256: sha256.New,
+	crypto.SHA384: sha512.New384,
+	crypto.SHA512: sha512.New,
+}
+
+// verify checks the signature and the time, issuer and audience claims of token, and returns its claims.
+func (j *JWTAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+	}{}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	hashAlg, found := crypto.Hash(0), false
+	if len(header.Alg) == 5 {
+		hashAlg, found = jwtHashes[header.Alg[2:]]
+	}
+	// Only algorithms we have a key for are accepted, so a token can't choose how it's verified.
+	switch {
+	case !found:
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	case strings.HasPrefix(header.Alg, "HS") && j.HMACKey != nil:
+		mac := hmac.New(hmacHashes[hashAlg], j.HMACKey)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return nil, fmt.Errorf("invalid signature")
+		}
+	case strings.HasPrefix(header.Alg, "RS") && j.RSAKey != nil:
+		h := hashAlg.New()
+		h.Write(signed)
+		if err := rsa.VerifyPKCS1v15(j.RSAKey, hashAlg, h.Sum(nil), signature); err != nil {
+			return nil, fmt.Errorf("invalid signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	claims := map[string]interface{}{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if exp, found := claims["exp"].(float64); found && now.After(time.Unix(int64(exp), 0).Add(j.Leeway)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if nbf, found := claims["nbf"].(float64); found && now.Before(time.Unix(int64(nbf), 0).Add(-j.Leeway)) {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+	if j.Issuer != "" && claims["iss"] != j.Issuer {
+		return nil, fmt.Errorf("wrong issuer")
+	}
+	if j.Audience != "" && !hasAudience(claims["aud"], j.Audience) {
+		return nil, fmt.Errorf("wrong audience")
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, dest interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("malformed token")
+	}
+	if err := json.Unmarshal(b, dest); err != nil {
+		return fmt.Errorf("malformed token")
+	}
+	return nil
+}
+
+// hasAudience returns whether aud, a string or list of strings, contains audience.
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, elem := range v {
+			if elem == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// BasicAuthenticator authenticates requests using HTTP Basic auth.
+type BasicAuthenticator struct {
+	Realm string
+	// Verify returns the Principal for username and password, or nil if they are wrong.
+	Verify func(r Request, username, password string) (*Principal, error)
+}
+
+func (b *BasicAuthenticator) Scheme() string {
+	return BasicScheme
+}
+
+func (b *BasicAuthenticator) Challenge(err error) string {
+	return challenge(BasicScheme, "realm", b.Realm, "charset", "UTF-8")
+}
+
+func (b *BasicAuthenticator) Authenticate(r Request) (*Principal, error) {
+	username, password, found := r.Req().BasicAuth()
+	if !found {
+		return nil, nil
+	}
+	principal, err := b.Verify(r, username, password)
+	if err != nil {
+		return nil, err
+	}
+	if principal == nil {
+		return nil, fmt.Errorf("wrong username or password")
+	}
+	return principal, nil
+}
+
+// APIKeyAuthenticator authenticates requests carrying an API key in a header or a query parameter.
+type APIKeyAuthenticator struct {
+	// Header is the header containing the key, if any.
+	Header string
+	// Query is the query parameter containing the key, if any.
+	Query string
+	// Lookup returns the Principal owning key, or nil if there is none.
+	Lookup func(r Request, key string) (*Principal, error)
+}
+
+func (a *APIKeyAuthenticator) Scheme() string {
+	return APIKeyScheme
+}
+
+func (a *APIKeyAuthenticator) Challenge(err error) string {
+	return challenge(APIKeyScheme, "header", a.Header, "query", a.Query)
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r Request) (*Principal, error) {
+	key := ""
+	if a.Header != "" {
+		key = r.Req().Header.Get(a.Header)
+	}
+	if key == "" && a.Query != "" {
+		key = r.Req().URL.Query().Get(a.Query)
+	}
+	if key == "" {
+		return nil, nil
+	}
+	principal, err := a.Lookup(r, key)
+	if err != nil {
+		return nil, err
+	}
+	if principal == nil {
+		return nil, fmt.Errorf("unknown API key")
+	}
+	return principal, nil
+}
+
+// CookieAuthenticator authenticates requests carrying a cookie signed by SetCookie.
+type CookieAuthenticator struct {
+	// Name is the name of the cookie.
+	Name string
+	// Key signs the cookie using HMAC-SHA256.
+	Key []byte
+	// MaxAge is how long cookies are valid.
+	MaxAge time.Duration
+}
+
+type cookiePayload struct {
+	ID      string                 `json:"id"`
+	Claims  map[string]interface{} `json:"claims,omitempty"`
+	Expires int64                  `json:"exp"`
+}
+
+func (c *CookieAuthenticator) Scheme() string {
+	return CookieScheme
+}
+
+func (c *CookieAuthenticator) Challenge(err error) string {
+	return ""
+}
+
+func (c *CookieAuthenticator) sign(payload string) string {
+	mac := hmac.New(sha256.New, c.Key)
+	mac.Write([]byte(c.Name + "=" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SetCookie makes w log in the client as principal.
+func (c *CookieAuthenticator) SetCookie(w http.ResponseWriter, principal *Principal) error {
+	b, err := json.Marshal(cookiePayload{
+		ID:      principal.ID,
+		Claims:  principal.Claims,
+		Expires: time.Now().Add(c.MaxAge).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.Name,
+		Value:    payload + "." + c.sign(payload),
+		Path:     "/",
+		MaxAge:   int(c.MaxAge / time.Second),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// ClearCookie makes w log out the client.
+func (c *CookieAuthenticator) ClearCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.Name,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+	})
+}
+
+func (c *CookieAuthenticator) Authenticate(r Request) (*Principal, error) {
+	cookie, err := r.Req().Cookie(c.Name)
+	if err != nil {
+		return nil, nil
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 2 || subtle.ConstantTimeCompare([]byte(c.sign(parts[0])), []byte(parts[1])) != 1 {
+		return nil, fmt.Errorf("invalid cookie")
+	}
+	payload := cookiePayload{}
+	if err := decodeJWTPart(parts[0], &payload); err != nil {
+		return nil, fmt.Errorf("invalid cookie")
+	}
+	if time.Now().Unix() > payload.Expires {
+		return nil, fmt.Errorf("cookie expired")
+	}
+	return &Principal{
+		ID:     payload.ID,
+		Claims: payload.Claims,
+	}, nil
+}
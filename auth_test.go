@@ -0,0 +1,662 @@
+package goaeoas
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signJWT(t *testing.T, alg string, key interface{}, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		h := sha256.Sum256([]byte(signed))
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, h[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestAuthentication(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacKey := []byte("secret")
+	cookieAuth := &CookieAuthenticator{
+		Name:   "session",
+		Key:    []byte("cookie secret"),
+		MaxAge: time.Hour,
+	}
+	// The cookie comes first, to check that invalid cookies don't hide other credentials.
+	SetAuthenticators(
+		cookieAuth,
+		&JWTAuthenticator{
+			HMACKey:  hmacKey,
+			RSAKey:   &rsaKey.PublicKey,
+			Issuer:   "issuer",
+			Audience: "api",
+			Realm:    "test",
+		},
+		&BasicAuthenticator{
+			Realm: "test",
+			Verify: func(r Request, username, password string) (*Principal, error) {
+				if password != "open sesame" {
+					return nil, nil
+				}
+				return &Principal{ID: username}, nil
+			},
+		},
+		&APIKeyAuthenticator{
+			Header: "X-API-Key",
+			Query:  "api_key",
+			Lookup: func(r Request, key string) (*Principal, error) {
+				if key != "k1" {
+					return nil, nil
+				}
+				return &Principal{ID: "robot"}, nil
+			},
+		},
+	)
+	defer SetAuthenticators()
+
+	now := time.Now().Unix()
+	claims := func(overrides ...interface{}) map[string]interface{} {
+		result := map[string]interface{}{
+			"sub": "alice",
+			"iss": "issuer",
+			"aud": []string{"other", "api"},
+			"exp": now + 60,
+			"nbf": now - 60,
+		}
+		for i := 0; i+1 < len(overrides); i += 2 {
+			result[overrides[i].(string)] = overrides[i+1]
+		}
+		return result
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	if err := cookieAuth.SetCookie(rec, &Principal{ID: "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	cookie := rec.Result().Cookies()[0]
+	tampered := *cookie
+	tampered.Value = base64.RawURLEncoding.EncodeToString([]byte(`{"id":"mallory","exp":9999999999}`)) + cookie.Value[strings.Index(cookie.Value, "."):]
+
+	for _, tc := range []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		apiKey        string
+		cookie        *http.Cookie
+		wantStatus    int
+		wantBody      string
+		wantChallenge string
+	}{
+		{
+			name:          "no credentials",
+			path:          "/Secret/1",
+			wantStatus:    401,
+			wantBody:      "authentication required",
+			wantChallenge: `Bearer realm="test", Basic realm="test", charset="UTF-8", APIKey header="X-API-Key", query="api_key"`,
+		},
+		{
+			name:          "HS256",
+			path:          "/Secret/1",
+			authorization: "Bearer " + signJWT(t, "HS256", hmacKey, claims()),
+			wantStatus:    200,
+			wantBody:      `"Owner":"Bearer:alice"`,
+		},
+		{
+			name:          "RS256",
+			path:          "/Secret/1",
+			authorization: "Bearer " + signJWT(t, "RS256", rsaKey, claims()),
+			wantStatus:    200,
+			wantBody:      `"Owner":"Bearer:alice"`,
+		},
+		{
+			name:          "wrong HMAC key",
+			path:          "/Secret/1",
+			authorization: "Bearer " + signJWT(t, "HS256", []byte("guess"), claims()),
+			wantStatus:    401,
+			wantBody:      "invalid signature",
+			wantChallenge: `Bearer realm="test", error="invalid_token", error_description="invalid signature", Basic realm="test", charset="UTF-8", APIKey header="X-API-Key", query="api_key"`,
+		},
+		{
+			name:          "alg none",
+			path:          "/Secret/1",
+			authorization: "Bearer " + strings.TrimSuffix(signJWT(t, "none", nil, claims()), "."),
+			wantStatus:    401,
+			wantBody:      "malformed token",
+		},
+		{
+			name:          "alg none with empty signature",
+			path:          "/Secret/1",
+			authorization: "Bearer " + signJWT(t, "none", nil, claims()),
+			wantStatus:    401,
+			wantBody:      `unsupported algorithm \"none\"`,
+		},
+		{
+			name:          "HS256 using the RSA public key",
+			path:          "/Secret/1",
+			authorization: "Bearer " + signJWT(t, "HS256", publicDER, claims()),
+			wantStatus:    401,
+			wantBody:      "invalid signature",
+		},
+		{
+			name:          "expired",
+			path:          "/Secret/1",
+			authorization: "Bearer " + signJWT(t, "HS256", hmacKey, claims("exp", now-60)),
+			wantStatus:    401,
+			wantBody:      "token expired",
+		},
+		{
+			name:          "not valid yet",
+			path:          "/Secret/1",
+			authorization: "Bearer " + signJWT(t, "HS256", hmacKey, claims("nbf", now+60)),
+			wantStatus:    401,
+			wantBody:      "token not valid yet",
+		},
+		{
+			name:          "wrong issuer",
+			path:          "/Secret/1",
+			authorization: "Bearer " + signJWT(t, "HS256", hmacKey, claims("iss", "someone")),
+			wantStatus:    401,
+			wantBody:      "wrong issuer",
+		},
+		{
+			name:          "wrong audience",
+			path:          "/Secret/1",
+			authorization: "Bearer " + signJWT(t, "HS256", hmacKey, claims("aud", "other")),
+			wantStatus:    401,
+			wantBody:      "wrong audience",
+		},
+		{
+			name:          "basic",
+			path:          "/Secret/1",
+			authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("bob:open sesame")),
+			wantStatus:    200,
+			wantBody:      `"Owner":"Basic:bob"`,
+		},
+		{
+			name:          "basic with wrong password",
+			path:          "/Secret/1",
+			authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("bob:guess")),
+			wantStatus:    401,
+			wantBody:      "wrong username or password",
+		},
+		{
+			name:       "API key header",
+			path:       "/Secret/1",
+			apiKey:     "k1",
+			wantStatus: 200,
+			wantBody:   `"Owner":"APIKey:robot"`,
+		},
+		{
+			name:       "API key query",
+			path:       "/Secret/1?api_key=k1",
+			wantStatus: 200,
+			wantBody:   `"Owner":"APIKey:robot"`,
+		},
+		{
+			name:       "unknown API key",
+			path:       "/Secret/1",
+			apiKey:     "k2",
+			wantStatus: 401,
+			wantBody:   "unknown API key",
+		},
+		{
+			name:       "cookie",
+			path:       "/Secret/1",
+			cookie:     cookie,
+			wantStatus: 200,
+			wantBody:   `"Owner":"Cookie:carol"`,
+		},
+		{
+			name:       "tampered cookie",
+			path:       "/Secret/1",
+			cookie:     &tampered,
+			wantStatus: 401,
+			wantBody:   "invalid cookie",
+		},
+		{
+			name:          "scheme not accepted by method",
+			method:        "PUT",
+			path:          "/Secret/1",
+			authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("bob:open sesame")),
+			wantStatus:    401,
+			wantBody:      "authentication required",
+			wantChallenge: `Bearer realm="test"`,
+		},
+		{
+			name:          "scheme accepted by method",
+			method:        "PUT",
+			path:          "/Secret/1",
+			authorization: "Bearer " + signJWT(t, "HS256", hmacKey, claims()),
+			wantStatus:    200,
+			wantBody:      `"Owner":"Bearer:alice"`,
+		},
+		{
+			name:          "scheme not accepted by lister",
+			path:          "/Secrets",
+			authorization: "Bearer " + signJWT(t, "HS256", hmacKey, claims()),
+			wantStatus:    401,
+			wantChallenge: `Basic realm="test", charset="UTF-8"`,
+		},
+		{
+			name:          "scheme accepted by lister",
+			path:          "/Secrets",
+			authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("bob:open sesame")),
+			wantStatus:    200,
+			wantBody:      `"Name":"bob"`,
+		},
+		{
+			name:       "route without requirement",
+			path:       "/Note/1",
+			wantStatus: 200,
+		},
+		{
+			name:          "tampered cookie with valid bearer",
+			path:          "/Secret/1",
+			cookie:        &tampered,
+			authorization: "Bearer " + signJWT(t, "HS256", hmacKey, claims()),
+			wantStatus:    200,
+			wantBody:      `"Owner":"Bearer:alice"`,
+		},
+		{
+			name:       "tampered cookie on route without requirement",
+			path:       "/Note/1",
+			cookie:     &tampered,
+			wantStatus: 200,
+		},
+		{
+			name:          "basic with wrong password on route without requirement",
+			path:          "/Note/1",
+			authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("bob:guess")),
+			wantStatus:    401,
+			wantBody:      "wrong username or password",
+		},
+	} {
+		method := tc.method
+		if method == "" {
+			method = "GET"
+		}
+		body := ""
+		if method == "PUT" {
+			body = "{}"
+		}
+		req := httptest.NewRequest(method, tc.path, strings.NewReader(body))
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Content-Type", "application/json")
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		if tc.apiKey != "" {
+			req.Header.Set("X-API-Key", tc.apiKey)
+		}
+		if tc.cookie != nil {
+			req.AddCookie(tc.cookie)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.wantStatus || !strings.Contains(rec.Body.String(), tc.wantBody) {
+			t.Errorf("%s: got %v %s, want %v with %s", tc.name, rec.Code, rec.Body.String(), tc.wantStatus, tc.wantBody)
+		}
+		if challenge := strings.Join(rec.Header().Values("WWW-Authenticate"), ", "); tc.wantChallenge != "" && challenge != tc.wantChallenge {
+			t.Errorf("%s: got challenge %q, want %q", tc.name, challenge, tc.wantChallenge)
+		}
+	}
+}
+
+func TestJWTLeeway(t *testing.T) {
+	key := []byte("secret")
+	auth := &JWTAuthenticator{HMACKey: key, Leeway: time.Minute}
+	token := signJWT(t, "HS256", key, map[string]interface{}{"sub": "alice", "exp": time.Now().Unix() - 30})
+	if _, err := auth.verify(token); err != nil {
+		t.Errorf("got %v, want token within leeway accepted", err)
+	}
+	rsaOnly := &JWTAuthenticator{RSAKey: &rsa.PublicKey{}}
+	if _, err := rsaOnly.verify(signJWT(t, "HS256", key, nil)); err == nil || !strings.Contains(err.Error(), "unsupported algorithm") {
+		t.Errorf("got %v, want HS256 rejected without HMAC key", err)
+	}
+	if got := auth.Challenge(nil); got != "Bearer" {
+		t.Errorf("got %q, want a challenge without params", got)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	SetAuthenticators(&APIKeyAuthenticator{
+		Header: "X-User",
+		Lookup: func(r Request, key string) (*Principal, error) {
+			return &Principal{ID: key}, nil
+		},
+	})
+	defer SetAuthenticators()
+
+	for _, tc := range []struct {
+		method      string
+		path        string
+		user        string
+		wantStatus  int
+		wantLinks   []string
+		wantNoLinks []string
+	}{
+		{
+			method:     "GET",
+			path:       "/Doc/1",
+			wantStatus: 403,
+		},
+		{
+			method:      "GET",
+			path:        "/Doc/1",
+			user:        "bob",
+			wantStatus:  200,
+			wantLinks:   []string{`"Rel":"self"`},
+			wantNoLinks: []string{`"Rel":"update"`},
+		},
+		{
+			method:     "GET",
+			path:       "/Doc/1",
+			user:       "alice",
+			wantStatus: 200,
+			wantLinks:  []string{`"Rel":"self"`, `"Rel":"update"`},
+		},
+		{
+			method:     "PUT",
+			path:       "/Doc/1",
+			user:       "bob",
+			wantStatus: 403,
+		},
+		{
+			method:     "PUT",
+			path:       "/Doc/1",
+			user:       "alice",
+			wantStatus: 200,
+		},
+		{
+			method:     "POST",
+			path:       "/Doc",
+			wantStatus: 403,
+		},
+		{
+			method:     "POST",
+			path:       "/Doc",
+			user:       "bob",
+			wantStatus: 200,
+		},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader("{}"))
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Content-Type", "application/json")
+		if tc.user != "" {
+			req.Header.Set("X-User", tc.user)
+		}
+		rec := httptest.NewRecorder()
+		docLoads = 0
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.wantStatus {
+			t.Errorf("%s %s as %q: got %v %s, want %v", tc.method, tc.path, tc.user, rec.Code, rec.Body.String(), tc.wantStatus)
+			continue
+		}
+		for _, link := range tc.wantLinks {
+			if !strings.Contains(rec.Body.String(), link) {
+				t.Errorf("%s %s as %q: got %s, want %s", tc.method, tc.path, tc.user, rec.Body.String(), link)
+			}
+		}
+		for _, link := range tc.wantNoLinks {
+			if strings.Contains(rec.Body.String(), link) {
+				t.Errorf("%s %s as %q: got %s, want no %s", tc.method, tc.path, tc.user, rec.Body.String(), link)
+			}
+		}
+		if tc.method == "GET" && tc.wantStatus == 200 && docLoads != 1 {
+			t.Errorf("%s %s as %q: loaded %v times, want once", tc.method, tc.path, tc.user, docLoads)
+		}
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	SetAuthenticators(&APIKeyAuthenticator{
+		Header: "X-User",
+		Lookup: func(r Request, key string) (*Principal, error) {
+			return &Principal{ID: key}, nil
+		},
+	})
+	defer SetAuthenticators()
+	SetCSRF(&CSRF{
+		TrustedOrigins: []string{"https://trusted.example.com"},
+		ExemptSchemes:  []string{APIKeyScheme},
+	})
+	defer SetCSRF(nil)
+	SetFormSubmissions(true)
+	defer SetFormSubmissions(false)
+
+	req := httptest.NewRequest("GET", "/Doc/1", nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("X-User", "alice")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "_csrf" || cookies[0].Value == "" || cookies[0].Secure {
+		t.Fatalf("got cookies %+v, want a CSRF cookie usable over HTTP", cookies)
+	}
+	token := cookies[0]
+	for _, tc := range []struct {
+		url            string
+		forwardedProto string
+		trustedProxies int
+		wantSecure     bool
+	}{
+		{url: "https://example.com/Doc/1", wantSecure: true},
+		{url: "/Doc/1", forwardedProto: "https", wantSecure: false},
+		{url: "/Doc/1", forwardedProto: "https", trustedProxies: 1, wantSecure: true},
+	} {
+		SetTrustedProxies(tc.trustedProxies)
+		secureReq := httptest.NewRequest("GET", tc.url, nil)
+		secureReq.Header.Set("Accept", "text/html")
+		secureReq.Header.Set("X-Forwarded-Proto", tc.forwardedProto)
+		secureRec := httptest.NewRecorder()
+		router.ServeHTTP(secureRec, secureReq)
+		if cookies := secureRec.Result().Cookies(); len(cookies) != 1 || cookies[0].Secure != tc.wantSecure {
+			t.Errorf("%s with X-Forwarded-Proto %q: got cookies %+v, want Secure %v", tc.url, tc.forwardedProto, cookies, tc.wantSecure)
+		}
+	}
+	SetTrustedProxies(0)
+	if want := `<input type="hidden" name="_csrf" value="` + token.Value + `"/>`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("got %s, want %s", rec.Body.String(), want)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        string
+		cookie      bool
+		header      string
+		origin      string
+		referer     string
+		user        string
+		wantStatus  int
+		wantBody    string
+	}{
+		{
+			name:        "no cookie",
+			contentType: "application/json",
+			body:        `{"Text":"x"}`,
+			header:      token.Value,
+			wantStatus:  403,
+			wantBody:    "missing CSRF token",
+		},
+		{
+			name:        "no token",
+			contentType: "application/json",
+			body:        `{"Text":"x"}`,
+			cookie:      true,
+			wantStatus:  403,
+			wantBody:    "invalid CSRF token",
+		},
+		{
+			name:        "wrong token",
+			contentType: "application/json",
+			body:        `{"Text":"x"}`,
+			cookie:      true,
+			header:      "guess",
+			wantStatus:  403,
+			wantBody:    "invalid CSRF token",
+		},
+		{
+			name:        "header token",
+			contentType: "application/json",
+			body:        `{"Text":"x"}`,
+			cookie:      true,
+			header:      token.Value,
+			wantStatus:  200,
+			wantBody:    `"Text":"x"`,
+		},
+		{
+			name:        "form token",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"Text": {"x"}, CSRFField: {token.Value}}.Encode(),
+			cookie:      true,
+			wantStatus:  200,
+			wantBody:    `"Text":"x"`,
+		},
+		{
+			name:        "other origin",
+			contentType: "application/json",
+			body:        `{"Text":"x"}`,
+			cookie:      true,
+			header:      token.Value,
+			origin:      "https://evil.example.com",
+			wantStatus:  403,
+			wantBody:    "cross origin request",
+		},
+		{
+			name:        "other referer",
+			contentType: "application/json",
+			body:        `{"Text":"x"}`,
+			cookie:      true,
+			header:      token.Value,
+			referer:     "https://evil.example.com/page",
+			wantStatus:  403,
+			wantBody:    "cross origin request",
+		},
+		{
+			name:        "same origin",
+			contentType: "application/json",
+			body:        `{"Text":"x"}`,
+			cookie:      true,
+			header:      token.Value,
+			origin:      "http://example.com",
+			wantStatus:  200,
+		},
+		{
+			name:        "trusted origin",
+			contentType: "application/json",
+			body:        `{"Text":"x"}`,
+			cookie:      true,
+			header:      token.Value,
+			origin:      "https://trusted.example.com",
+			wantStatus:  200,
+		},
+		{
+			name:        "exempt scheme",
+			contentType: "application/json",
+			body:        `{"Text":"x"}`,
+			user:        "robot",
+			origin:      "https://evil.example.com",
+			wantStatus:  200,
+		},
+	} {
+		req := httptest.NewRequest("POST", "/Memo", strings.NewReader(tc.body))
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Content-Type", tc.contentType)
+		if tc.cookie {
+			req.AddCookie(token)
+		}
+		if tc.header != "" {
+			req.Header.Set("X-CSRF-Token", tc.header)
+		}
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		if tc.referer != "" {
+			req.Header.Set("Referer", tc.referer)
+		}
+		if tc.user != "" {
+			req.Header.Set("X-User", tc.user)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.wantStatus || !strings.Contains(rec.Body.String(), tc.wantBody) {
+			t.Errorf("%s: got %v %s, want %v with %s", tc.name, rec.Code, rec.Body.String(), tc.wantStatus, tc.wantBody)
+		}
+	}
+}
+
+func TestRateLimitFailedAuthentication(t *testing.T) {
+	SetAuthenticators(&BasicAuthenticator{
+		Realm: "test",
+		Verify: func(r Request, username, password string) (*Principal, error) {
+			if password != "open sesame" {
+				return nil, nil
+			}
+			return &Principal{ID: username}, nil
+		},
+	})
+	defer SetAuthenticators()
+	defer SetRateLimit(nil)
+
+	for _, key := range []func(Request) string{nil, PrincipalRateLimitKey} {
+		SetRateLimitStore(NewMemoryRateLimitStore())
+		SetRateLimit(&RateLimit{
+			Requests: 2,
+			Period:   time.Minute,
+			Key:      key,
+		})
+		for i, tc := range []struct {
+			password   string
+			wantStatus int
+		}{
+			{password: "guess 1", wantStatus: 401},
+			{password: "guess 2", wantStatus: 401},
+			{password: "guess 3", wantStatus: 429},
+		} {
+			req := httptest.NewRequest("GET", "/Secrets", nil)
+			req.Header.Set("Accept", "application/json")
+			req.SetBasicAuth("alice", tc.password)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tc.wantStatus {
+				t.Errorf("keyed %v, guess %v: got %v %s, want %v", key != nil, i, rec.Code, rec.Body.String(), tc.wantStatus)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package goaeoas
+
+import (
+	"context"
+	"io"
+)
+
+// Key identifies a request scoped value of type T, typically set by a filter
+// and read by handlers.
+// The value is stored in Request.Values under the name of the key, so names
+// must be unique.
+type Key[T any] struct {
+	name string
+}
+
+// NewKey returns a Key for values of type T stored under name.
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: name}
+}
+
+// Name returns the name the values of k are stored under in Request.Values.
+func (k Key[T]) Name() string {
+	return k.name
+}
+
+// Get returns the value of k in r, and whether it was found.
+func (k Key[T]) Get(r Request) (T, bool) {
+	val, found := r.Values()[k.name].(T)
+	return val, found
+}
+
+// Set stores val as the value of k in r.
+func (k Key[T]) Set(r Request, val T) {
+	r.Values()[k.name] = val
+}
+
+// Delete removes the value of k from r.
+func (k Key[T]) Delete(r Request) {
+	delete(r.Values(), k.name)
+}
+
+// contextWriter stops writing when its context is done, so that renders
+// for clients that went away are aborted.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c contextWriter) Write(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(b)
+}
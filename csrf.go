@@ -0,0 +1,195 @@
+package goaeoas
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"google.golang.org/appengine/v2"
+)
+
+const (
+	// CSRFField is the form field native HTML forms send the CSRF token in.
+	CSRFField = "_csrf"
+)
+
+var (
+	csrf *CSRF
+	// csrfTokenKey holds the CSRF token of requests when CSRF protection is enabled.
+	csrfTokenKey = NewKey[string]("goaeoas.csrf")
+)
+
+// CSRF configures protection against cross site request forgery, using a token stored in a cookie
+// that requests with unsafe methods must send back in a header or form field. The cookie is only
+// marked Secure for requests using HTTPS, so that plain HTTP deployments keep working.
+type CSRF struct {
+	// CookieName is the name of the cookie holding the token, "_csrf" if empty.
+	CookieName string
+	// HeaderName is the header scripts send the token in, "X-CSRF-Token" if empty.
+	HeaderName string
+	// TrustedOrigins are origins, like "https://example.com", allowed to send requests
+	// besides the origin of the API itself.
+	TrustedOrigins []string
+	// ExemptSchemes are the authentication schemes whose requests aren't checked, since browsers
+	// don't send their credentials on their own. Only Bearer if nil.
+	ExemptSchemes []string
+}
+
+// SetCSRF enables CSRF protection of all routes, or disables it if c is nil.
+// When enabled, POST, PUT, DELETE and PATCH requests without the CSRF token of the client, or from
+// other origins, fail with 403 unless authenticated using one of the ExemptSchemes. The forms of
+// the browsable API send the token, and other clients find it using CSRFToken.
+func SetCSRF(c *CSRF) {
+	if c == nil {
+		if formSubmissions {
+			panic("form submissions require CSRF protection, call SetFormSubmissions(false) first")
+		}
+		csrf = nil
+		return
+	}
+	cpy := *c
+	if cpy.CookieName == "" {
+		cpy.CookieName = "_csrf"
+	}
+	if cpy.HeaderName == "" {
+		cpy.HeaderName = "X-CSRF-Token"
+	}
+	if cpy.ExemptSchemes == nil {
+		cpy.ExemptSchemes = []string{BearerScheme}
+	}
+	csrf = &cpy
+}
+
+// CSRFToken returns the CSRF token of the client of r, or "" if CSRF protection isn't enabled.
+func CSRFToken(r Request) string {
+	token, _ := csrfTokenKey.Get(r)
+	return token
+}
+
+// checkCSRF gives the client of r a CSRF token if it has none, and verifies the token and origin
+// of requests with unsafe methods.
+func checkCSRF(w ResponseWriter, r *request) error {
+	if csrf == nil {
+		return nil
+	}
+	token := ""
+	if cookie, err := r.req.Cookie(csrf.CookieName); err == nil {
+		token = cookie.Value
+	}
+	submittable := token != ""
+	if !submittable {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		token = base64.RawURLEncoding.EncodeToString(b)
+		http.SetCookie(w, &http.Cookie{
+			Name:     csrf.CookieName,
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   secureRequest(r.req),
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	csrfTokenKey.Set(r, token)
+	if safeMethod(r.req.Method) || csrfExempt(r) {
+		return nil
+	}
+	if !submittable {
+		return HTTPErr{Body: "missing CSRF token", Status: http.StatusForbidden}
+	}
+	if !trustedOrigin(r.req) {
+		return HTTPErr{Body: "cross origin request", Status: http.StatusForbidden}
+	}
+	submitted := r.req.Header.Get(csrf.HeaderName)
+	if submitted == "" && r.formSubmission {
+		var err error
+		if submitted, err = formCSRFToken(r.req); err != nil {
+			return err
+		}
+	}
+	if subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+		return HTTPErr{Body: "invalid CSRF token", Status: http.StatusForbidden}
+	}
+	return nil
+}
+
+// secureRequest returns whether r uses HTTPS, directly, according to DefaultScheme, or according
+// to the X-Forwarded-Proto header of App Engine or trusted proxies.
+func secureRequest(r *http.Request) bool {
+	if r.TLS != nil || DefaultScheme == "https" {
+		return true
+	}
+	if appengine.IsAppEngine() || trustedProxies > 0 {
+		return r.Header.Get("X-Forwarded-Proto") == "https"
+	}
+	return false
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// csrfExempt returns whether r was authenticated using one of the ExemptSchemes.
+func csrfExempt(r Request) bool {
+	principal, found := PrincipalKey.Get(r)
+	if !found {
+		return false
+	}
+	for _, scheme := range csrf.ExemptSchemes {
+		if principal.Scheme == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedOrigin returns whether the Origin, or lacking that the Referer, of r is the host of r or
+// one of the TrustedOrigins. Requests with neither are trusted, and left to the token check.
+func trustedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		referer, err := url.Parse(r.Header.Get("Referer"))
+		if err != nil {
+			return false
+		}
+		if referer.Host == "" {
+			return origin == ""
+		}
+		origin = referer.Scheme + "://" + referer.Host
+	}
+	for _, trusted := range csrf.TrustedOrigins {
+		if strings.EqualFold(origin, trusted) {
+			return true
+		}
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(parsed.Host, r.Host)
+}
+
+// formCSRFToken returns the CSRF token of the form body of r, leaving the body readable by Copy.
+func formCSRFToken(r *http.Request) (string, error) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	values, err := url.ParseQuery(string(b))
+	if err != nil {
+		return "", HTTPErr{Body: err.Error(), Status: http.StatusBadRequest}
+	}
+	return values.Get(CSRFField), nil
+}
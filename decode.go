@@ -0,0 +1,530 @@
+package goaeoas
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+const (
+	// UnknownField is the RejectedField reason for fields the type doesn't have.
+	UnknownField = "unknown"
+	// NotWritableField is the RejectedField reason for fields that can't be written using the method of the request.
+	NotWritableField = "not writable"
+)
+
+// RejectedField is a field of a request body that was skipped by Copy.
+type RejectedField struct {
+	Path   string
+	Reason string
+}
+
+// RejectedFieldsErr is returned by Copy for Resources with Strict set, when
+// the request body contains fields that would otherwise be silently skipped.
+type RejectedFieldsErr struct {
+	Method string
+	Fields []RejectedField
+}
+
+func (r RejectedFieldsErr) Error() string {
+	parts := make([]string, 0, len(r.Fields))
+	for _, field := range r.Fields {
+		reason := field.Reason
+		if reason == NotWritableField {
+			reason = fmt.Sprintf("%s using %s", reason, r.Method)
+		}
+		parts = append(parts, fmt.Sprintf("%s (%s)", field.Path, reason))
+	}
+	return fmt.Sprintf("rejected fields: %s", strings.Join(parts, ", "))
+}
+
+// jsonFilter decodes JSON values field by field, skipping the fields not
+// present in the DocType for method.
+type jsonFilter struct {
+	dec    *json.Decoder
+	method string
+	strict bool
+	// fresh is set when decoding into a new value, which WriteOnce fields can't be compared with.
+	fresh     bool
+	rejected  []RejectedField
+	immutable map[string]string
+}
+
+// decodeJSON decodes the JSON object in r into dest in a single pass, skipping
+// the fields not present in the DocType of dest for method.
+// If strict, skipped fields cause a RejectedFieldsErr.
+// If fresh, dest is a new value and WriteOnce fields are skipped like Generated fields instead of compared.
+func decodeJSON(dest interface{}, r io.Reader, method string, strict, fresh bool) error {
+	val := reflect.ValueOf(dest)
+	if val.Kind() != reflect.Ptr {
+		return fmt.Errorf("can only copy to pointer to struct")
+	}
+	val = val.Elem()
+	if val.Kind() != reflect.Struct {
+		return fmt.Errorf("can only copy to pointer to struct")
+	}
+	docType, err := NewDocType(val.Type(), method)
+	if err != nil {
+		return err
+	}
+	filter := &jsonFilter{
+		dec:    json.NewDecoder(r),
+		method: method,
+		strict: strict,
+		fresh:  fresh,
+	}
+	if err := filter.decode("", val, docType); err != nil {
+		return err
+	}
+	if _, err := filter.dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after top-level JSON value")
+	}
+	if len(filter.rejected) > 0 {
+		sort.Slice(filter.rejected, func(i, j int) bool {
+			return filter.rejected[i].Path < filter.rejected[j].Path
+		})
+		return RejectedFieldsErr{
+			Method: method,
+			Fields: filter.rejected,
+		}
+	}
+	if len(filter.immutable) > 0 {
+		return ValidationErr{Fields: filter.immutable}
+	}
+	return nil
+}
+
+// decode decodes the next JSON value into val, skipping object keys
+// that don't match fields of docType.
+func (f *jsonFilter) decode(path string, val reflect.Value, docType *DocType) error {
+	if !isFiltered(docType.typ) {
+		if err := f.dec.Decode(val.Addr().Interface()); err != nil {
+			return decodeErr(path, err)
+		}
+		return nil
+	}
+	if docType.typ.Kind() == reflect.Interface {
+		return f.decodeUnion(path, val, docType)
+	}
+	tok, err := f.dec.Token()
+	if err != nil {
+		return decodeErr(path, err)
+	}
+	return f.decodeToken(path, val, docType, tok)
+}
+
+// isFiltered returns whether values of typ contain structs decoded field by field, instead of by encoding/json.
+func isFiltered(typ reflect.Type) bool {
+	if typ.Implements(jsonUnmarshalerType) {
+		return false
+	}
+	switch typ.Kind() {
+	case reflect.Interface:
+		_, found := unions[typ]
+		return found
+	case reflect.Struct:
+		return isFilteredStruct(typ)
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return isFiltered(typ.Elem())
+	}
+	return false
+}
+
+// isFilteredStruct returns whether values of typ are decoded field by field, instead of by encoding/json.
+func isFilteredStruct(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Struct && !reflect.PtrTo(typ).Implements(jsonUnmarshalerType)
+}
+
+// decodeToken decodes the JSON value starting with tok into val, which must be of a type where isFiltered is true.
+func (f *jsonFilter) decodeToken(path string, val reflect.Value, docType *DocType, tok json.Token) error {
+	switch docType.typ.Kind() {
+	case reflect.Struct:
+		// Like encoding/json, null leaves structs untouched.
+		if tok == nil {
+			return nil
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+			return mismatchErr(path, "object", tok)
+		}
+		return f.decodeObject(path, val, docType)
+	case reflect.Ptr:
+		if tok == nil {
+			val.Set(reflect.Zero(val.Type()))
+			return nil
+		}
+		elemDocType, err := elemDocType(docType)
+		if err != nil {
+			return err
+		}
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		return f.decodeToken(path, val.Elem(), elemDocType, tok)
+	case reflect.Slice:
+		if tok == nil {
+			val.Set(reflect.Zero(val.Type()))
+			return nil
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			return mismatchErr(path, "array", tok)
+		}
+		return f.decodeArray(path, val, docType)
+	case reflect.Array:
+		if tok == nil {
+			return nil
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			return mismatchErr(path, "array", tok)
+		}
+		return f.decodeFixedArray(path, val, docType)
+	case reflect.Map:
+		if tok == nil {
+			val.Set(reflect.Zero(val.Type()))
+			return nil
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+			return mismatchErr(path, "object", tok)
+		}
+		return f.decodeMap(path, val, docType)
+	}
+	return fmt.Errorf("%v can't be filtered", docType.typ)
+}
+
+func (f *jsonFilter) decodeObject(path string, val reflect.Value, docType *DocType) error {
+	for f.dec.More() {
+		keyTok, err := f.dec.Token()
+		if err != nil {
+			return decodeErr(path, err)
+		}
+		key := keyTok.(string)
+		field, found := docType.GetField(key)
+		if !found {
+			if err := f.skip(joinPath(path, key), val, docType, key); err != nil {
+				return err
+			}
+			continue
+		}
+		fieldVal, err := field.value(val)
+		if err != nil {
+			return HTTPErr{Body: fmt.Sprintf("can't set %s: %v", joinPath(path, key), err), Status: 400}
+		}
+		if field.String {
+			err = f.decodeQuoted(joinPath(path, key), fieldVal)
+		} else {
+			err = f.decode(joinPath(path, key), fieldVal, field.Type)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := f.dec.Token(); err != nil {
+		return decodeErr(path, err)
+	}
+	return nil
+}
+
+// decodeUnion decodes the next JSON value into val, an interface registered using RegisterUnion,
+// using the variant named by its discriminator. If val already contains that variant, the
+// value is decoded on top of a copy of it.
+func (f *jsonFilter) decodeUnion(path string, val reflect.Value, docType *DocType) error {
+	raw := json.RawMessage{}
+	if err := f.dec.Decode(&raw); err != nil {
+		return decodeErr(path, err)
+	}
+	if string(raw) == "null" {
+		val.Set(reflect.Zero(val.Type()))
+		return nil
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return mismatchErr(path, "object", string(raw))
+	}
+	name := ""
+	if err := json.Unmarshal(fields[docType.Discriminator], &name); err != nil || name == "" {
+		return HTTPErr{Body: fmt.Sprintf("missing or invalid %s for %s", docType.Discriminator, describePath(path)), Status: 400}
+	}
+	variantDocType, found := docType.Variants[name]
+	if !found {
+		return HTTPErr{Body: fmt.Sprintf("unknown %s %q for %s", docType.Discriminator, name, describePath(path)), Status: 400}
+	}
+	variant := unions[docType.typ].Variants[name]
+	delete(fields, docType.Discriminator)
+	rest, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	target := reflect.New(variantDocType.typ)
+	if !val.IsNil() && val.Elem().Type() == variant {
+		if variant.Kind() == reflect.Ptr {
+			target.Elem().Set(val.Elem().Elem())
+		} else {
+			target.Elem().Set(val.Elem())
+		}
+	}
+	variantFilter := &jsonFilter{
+		dec:    json.NewDecoder(bytes.NewReader(rest)),
+		method: f.method,
+		strict: f.strict,
+		fresh:  f.fresh,
+	}
+	if err := variantFilter.decode(path, target.Elem(), variantDocType); err != nil {
+		return err
+	}
+	f.rejected = append(f.rejected, variantFilter.rejected...)
+	for key, msg := range variantFilter.immutable {
+		if f.immutable == nil {
+			f.immutable = map[string]string{}
+		}
+		f.immutable[key] = msg
+	}
+	discriminator, _ := discriminatorField(variantDocType.typ, docType.Discriminator)
+	discriminatorVal, err := discriminator.value(target.Elem())
+	if err != nil {
+		return err
+	}
+	discriminatorVal.SetString(name)
+	if variant.Kind() == reflect.Ptr {
+		val.Set(target)
+	} else {
+		val.Set(target.Elem())
+	}
+	return nil
+}
+
+// decodeQuoted decodes the next JSON value into val the way encoding/json does for fields with the string option.
+func (f *jsonFilter) decodeQuoted(path string, val reflect.Value) error {
+	raw := json.RawMessage{}
+	if err := f.dec.Decode(&raw); err != nil {
+		return decodeErr(path, err)
+	}
+	wrapper := reflect.New(reflect.StructOf([]reflect.StructField{
+		{
+			Name: "Value",
+			Type: val.Type(),
+			Tag:  `json:"value,string"`,
+		},
+	}))
+	wrapper.Elem().Field(0).Set(val)
+	if err := json.Unmarshal([]byte(`{"value":`+string(raw)+`}`), wrapper.Interface()); err != nil {
+		return HTTPErr{Body: fmt.Sprintf("invalid JSON for %s: %v", path, err), Status: 400}
+	}
+	val.Set(wrapper.Elem().Field(0))
+	return nil
+}
+
+func (f *jsonFilter) decodeArray(path string, val reflect.Value, docType *DocType) error {
+	result := reflect.MakeSlice(val.Type(), 0, 0)
+	for idx := 0; f.dec.More(); idx++ {
+		elem := reflect.New(val.Type().Elem()).Elem()
+		if err := f.decode(fmt.Sprintf("%s[%d]", path, idx), elem, docType.Elem); err != nil {
+			return err
+		}
+		result = reflect.Append(result, elem)
+	}
+	if _, err := f.dec.Token(); err != nil {
+		return decodeErr(path, err)
+	}
+	val.Set(result)
+	return nil
+}
+
+// decodeFixedArray decodes into Go arrays, which like in encoding/json drop extra elements and zero missing ones.
+func (f *jsonFilter) decodeFixedArray(path string, val reflect.Value, docType *DocType) error {
+	elemDocType, err := elemDocType(docType)
+	if err != nil {
+		return err
+	}
+	idx := 0
+	for ; f.dec.More(); idx++ {
+		if idx >= val.Len() {
+			if err := f.dec.Decode(&json.RawMessage{}); err != nil {
+				return decodeErr(path, err)
+			}
+			continue
+		}
+		elem := reflect.New(val.Type().Elem()).Elem()
+		if err := f.decode(fmt.Sprintf("%s[%d]", path, idx), elem, elemDocType); err != nil {
+			return err
+		}
+		val.Index(idx).Set(elem)
+	}
+	for ; idx < val.Len(); idx++ {
+		val.Index(idx).Set(reflect.Zero(val.Type().Elem()))
+	}
+	if _, err := f.dec.Token(); err != nil {
+		return decodeErr(path, err)
+	}
+	return nil
+}
+
+func (f *jsonFilter) decodeMap(path string, val reflect.Value, docType *DocType) error {
+	elemDocType, err := elemDocType(docType)
+	if err != nil {
+		return err
+	}
+	if val.IsNil() {
+		val.Set(reflect.MakeMap(val.Type()))
+	}
+	for f.dec.More() {
+		keyTok, err := f.dec.Token()
+		if err != nil {
+			return decodeErr(path, err)
+		}
+		key, err := mapKey(val.Type().Key(), keyTok.(string))
+		if err != nil {
+			return HTTPErr{Body: fmt.Sprintf("invalid key %q in %s: %v", keyTok, path, err), Status: 400}
+		}
+		elem := reflect.New(val.Type().Elem()).Elem()
+		if err := f.decode(joinPath(path, keyTok.(string)), elem, elemDocType); err != nil {
+			return err
+		}
+		val.SetMapIndex(key, elem)
+	}
+	if _, err := f.dec.Token(); err != nil {
+		return decodeErr(path, err)
+	}
+	return nil
+}
+
+// mapKey converts s to a map key of type typ, the way encoding/json does.
+func mapKey(typ reflect.Type, s string) (reflect.Value, error) {
+	if typ.Kind() != reflect.String && reflect.PtrTo(typ).Implements(textUnmarshalerType) {
+		key := reflect.New(typ)
+		if err := key.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return reflect.Value{}, err
+		}
+		return key.Elem(), nil
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(s).Convert(typ), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(i).Convert(typ), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(s, 10, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(u).Convert(typ), nil
+	}
+	return reflect.Value{}, fmt.Errorf("unsupported map key type %v", typ)
+}
+
+// elemDocType returns the DocType of the elements of docType.
+func elemDocType(docType *DocType) (*DocType, error) {
+	if docType.Elem != nil {
+		return docType.Elem, nil
+	}
+	return NewDocType(docType.typ.Elem(), docType.method)
+}
+
+// mismatchErr reports a JSON value of the wrong type at path.
+func mismatchErr(path, want string, got json.Token) error {
+	return HTTPErr{Body: fmt.Sprintf("expected JSON %s for %s, got %v", want, describePath(path), got), Status: 400}
+}
+
+// describePath returns path, or "body" for the top-level value.
+func describePath(path string) string {
+	if path == "" {
+		return "body"
+	}
+	return path
+}
+
+// decodeErr converts errors caused by malformed JSON at path to HTTPErrs with status 400.
+func decodeErr(path string, err error) error {
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+	default:
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+	}
+	return HTTPErr{Body: fmt.Sprintf("invalid JSON for %s: %v", describePath(path), err), Status: 400}
+}
+
+// skip handles the next JSON value when key isn't a field of docType. Values for WriteOnce fields are
+// compared to the current value of the field in val unless it's fresh, other values are discarded and
+// recorded as rejected if the filter is strict.
+func (f *jsonFilter) skip(path string, val reflect.Value, docType *DocType, key string) error {
+	allFields, err := NewDocType(docType.typ, "")
+	if err != nil {
+		return err
+	}
+	field, found := allFields.GetField(key)
+	if found && field.Access == WriteOnce && !f.fresh {
+		return f.compareWriteOnce(path, val, field)
+	}
+	if err := f.dec.Decode(&json.RawMessage{}); err != nil {
+		return decodeErr(path, err)
+	}
+	if !f.strict || (found && (field.Access == Generated || field.Access == WriteOnce)) {
+		return nil
+	}
+	reason := UnknownField
+	if found {
+		reason = NotWritableField
+	}
+	f.rejected = append(f.rejected, RejectedField{
+		Path:   path,
+		Reason: reason,
+	})
+	return nil
+}
+
+// compareWriteOnce decodes the next JSON value on top of a copy of field in val, and records
+// an error if that changes the JSON encoding of the field.
+func (f *jsonFilter) compareWriteOnce(path string, val reflect.Value, field *DocField) error {
+	current, err := field.value(val)
+	if err != nil {
+		return HTTPErr{Body: fmt.Sprintf("can't set %s: %v", path, err), Status: 400}
+	}
+	original := reflect.New(current.Type())
+	original.Elem().Set(current)
+	updated := reflect.New(current.Type())
+	updated.Elem().Set(current)
+	if field.String {
+		if err := f.decodeQuoted(path, updated.Elem()); err != nil {
+			return err
+		}
+	} else if err := f.dec.Decode(updated.Interface()); err != nil {
+		return decodeErr(path, err)
+	}
+	before, err := json.Marshal(original.Interface())
+	if err != nil {
+		return err
+	}
+	after, err := json.Marshal(updated.Interface())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(before, after) {
+		if f.immutable == nil {
+			f.immutable = map[string]string{}
+		}
+		f.immutable[path] = "can only be set when creating"
+	}
+	return nil
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
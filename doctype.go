@@ -5,33 +5,163 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 )
 
+const (
+	// ReadOnly fields, tagged `access:"readonly"`, are shown but never accepted.
+	ReadOnly = "readonly"
+	// WriteOnce fields, tagged `access:"writeonce"`, can only be set using POST. Requests
+	// trying to change them using other methods fail with a ValidationErr, except when decoded
+	// into new values, like the bodies of UpdateBody handlers, where they are ignored.
+	WriteOnce = "writeonce"
+	// Generated fields, tagged `access:"generated"`, are computed by the server, like IDs and timestamps.
+	// They are never accepted, but ignored even in strict mode since clients commonly send back what
+	// they received.
+	Generated = "generated"
+	// WriteOnly fields, tagged `access:"writeonly"`, are accepted using the methods in their `methods` tag
+	// but never shown. They are zeroed wherever they are in the Properties of marshalled Items, so combine
+	// them with `json:",omitempty"` to leave them out of the JSON entirely. Since embedded pointers to
+	// unexported structs can't be replaced, marshalling fails if they have non zero WriteOnly fields.
+	WriteOnly = "writeonly"
+)
+
+// DocType describes the fields of a type that can be read or written using an HTTP method.
+// The method "GET" gives the fields shown in responses, and the empty method gives all fields.
 type DocType struct {
-	Kind   string
-	Name   string
-	Elem   *DocType
-	Fields []DocField
-	typ    reflect.Type
-	method string
+	Kind string
+	Name string
+	// Elem is the DocType of the elements of pointers, slices, arrays and maps.
+	Elem *DocType
+	// Discriminator and Variants describe interface types registered using RegisterUnion.
+	Discriminator string
+	Variants      map[string]*DocType
+	Fields        []DocField
+	typ           reflect.Type
+	method        string
 }
 
+// GetField returns the field named n, preferring an exact match but
+// accepting a case insensitive match like encoding/json does.
 func (d DocType) GetField(n string) (*DocField, bool) {
 	for _, field := range d.Fields {
 		if field.Name == n {
 			return &field, true
 		}
 	}
+	for _, field := range d.Fields {
+		if strings.EqualFold(field.Name, n) {
+			return &field, true
+		}
+	}
 	return nil, false
 }
 
 type JSONSchema struct {
-	Type                 string                `json:"type"`
-	Properties           map[string]JSONSchema `json:"properties,omitempty"`
-	AdditionalProperties *JSONSchema           `json:"additionalProperties,omitempty"`
-	Items                *JSONSchema           `json:"items,omitempty"`
-	Title                string                `json:"title,omitempty"`
+	Type                 string                   `json:"type"`
+	Properties           map[string]JSONSchema    `json:"properties,omitempty"`
+	Required             []string                 `json:"required,omitempty"`
+	AdditionalProperties *JSONSchema              `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema              `json:"items,omitempty"`
+	MinItems             *int                     `json:"minItems,omitempty"`
+	MaxItems             *int                     `json:"maxItems,omitempty"`
+	Minimum              *float64                 `json:"minimum,omitempty"`
+	ContentEncoding      string                   `json:"contentEncoding,omitempty"`
+	Title                string                   `json:"title,omitempty"`
+	Ref                  string                   `json:"$ref,omitempty"`
+	Definitions          map[string]JSONSchema    `json:"definitions,omitempty"`
+	OneOf                []JSONSchema             `json:"oneOf,omitempty"`
+	AnyOf                []JSONSchema             `json:"anyOf,omitempty"`
+	Discriminator        *JSONSchemaDiscriminator `json:"discriminator,omitempty"`
+	ReadOnly             bool                     `json:"readOnly,omitempty"`
+	WriteOnly            bool                     `json:"writeOnly,omitempty"`
+	// Nullable schemas also accept null, and are encoded with "null" in their list of types.
+	Nullable bool `json:"-"`
+}
+
+// JSONSchemaDiscriminator tells which of the schemas in a oneOf a value matches.
+type JSONSchemaDiscriminator struct {
+	PropertyName string            `json:"propertyName"`
+	Mapping      map[string]string `json:"mapping,omitempty"`
+}
+
+// MarshalJSON encodes nullable schemas with a list of types, and leaves the type
+// out of schemas accepting anything.
+func (s JSONSchema) MarshalJSON() ([]byte, error) {
+	type plainSchema JSONSchema
+	var typ interface{}
+	if s.Type != "" {
+		if s.Nullable {
+			typ = []string{s.Type, "null"}
+		} else {
+			typ = s.Type
+		}
+	}
+	return json.Marshal(struct {
+		Type interface{} `json:"type,omitempty"`
+		plainSchema
+	}{
+		Type:        typ,
+		plainSchema: plainSchema(s),
+	})
+}
+
+// TypeMapping describes how the generators represent a Go type.
+type TypeMapping struct {
+	// JSONSchema returns the schema of the type.
+	JSONSchema func() *JSONSchema
+	// Java is the Java type of the type.
+	Java string
+}
+
+// JSONSchemaer is implemented by types that supply their own JSON Schema.
+type JSONSchemaer interface {
+	JSONSchema() *JSONSchema
+}
+
+// JavaTyper is implemented by types that supply their own Java type.
+type JavaTyper interface {
+	JavaType() string
+}
+
+var (
+	typeMappings = map[reflect.Type]TypeMapping{}
+)
+
+// RegisterType makes the generators represent typ using mapping, instead of by its kind.
+// Types you control can implement JSONSchemaer and JavaTyper instead.
+func RegisterType(typ reflect.Type, mapping TypeMapping) {
+	typeMappings[typ] = mapping
+	jsonSchemas.Range(func(key, value interface{}) bool {
+		jsonSchemas.Delete(key)
+		return true
+	})
+}
+
+func typeMappingFor(typ reflect.Type) (TypeMapping, bool) {
+	if mapping, found := typeMappings[typ]; found {
+		return mapping, true
+	}
+	if typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Interface {
+		return TypeMapping{}, false
+	}
+	mapping := TypeMapping{}
+	// A pointer to a zero value has both the value and the pointer methods.
+	zero := reflect.New(typ).Interface()
+	if schemaer, ok := zero.(JSONSchemaer); ok {
+		mapping.JSONSchema = schemaer.JSONSchema
+	}
+	if javaTyper, ok := zero.(JavaTyper); ok {
+		mapping.Java = javaTyper.JavaType()
+	}
+	return mapping, mapping.JSONSchema != nil || mapping.Java != ""
+}
+
+// implements returns whether typ or a pointer to typ implements iface.
+func implements(typ, iface reflect.Type) bool {
+	return typ.Implements(iface) || reflect.PtrTo(typ).Implements(iface)
 }
 
 func (d DocType) ToJavaClasses(pkg, meth string) (map[string]string, error) {
@@ -46,14 +176,24 @@ func (d DocType) populateJavaClasses(javaClasses map[string]string, pkg, meth st
 	if _, found := javaClasses[d.typ.Name()]; found {
 		return nil
 	}
+	// Claim the name before describing the fields, in case they refer back to this type.
+	javaClasses[d.typ.Name()] = ""
+
+	extends := ""
+	if u := unionOf(d.typ); u != nil {
+		if err := populateJavaUnion(javaClasses, u, pkg, meth); err != nil {
+			return err
+		}
+		extends = fmt.Sprintf(" extends %s", u.Interface.Name())
+	}
 
 	buf := &bytes.Buffer{}
 	fmt.Fprintf(buf, `package %s;
 
 import retrofit2.http.*;
 	
-public class %s implements java.io.Serializable {
-`, pkg, d.typ.Name())
+public class %s%s implements java.io.Serializable {
+`, pkg, d.typ.Name(), extends)
 
 	for _, field := range d.Fields {
 		if field.field.Tag.Get("skip") == "" {
@@ -61,8 +201,26 @@ public class %s implements java.io.Serializable {
 			if err != nil {
 				return err
 			}
+			if field.String {
+				javaType = "String"
+			}
+			comments := []string{}
+			if comment := javaAccessComments[field.Access]; comment != "" {
+				comments = append(comments, comment)
+			}
+			if field.OmitEmpty && field.Access != WriteOnly {
+				comments = append(comments, "Left out by the server when empty.")
+			}
+			if len(comments) > 0 {
+				fmt.Fprintf(buf, `  /** %s */
+`, strings.Join(comments, " "))
+			}
+			if field.Name != field.field.Name {
+				fmt.Fprintf(buf, `  @com.google.gson.annotations.SerializedName(%q)
+`, field.Name)
+			}
 			fmt.Fprintf(buf, `  public %s %s;
-`, javaType, field.Name)
+`, javaType, field.field.Name)
 		}
 	}
 
@@ -152,12 +310,22 @@ public class MultiContainer<T> implements java.io.Serializable {
 	return nil
 }
 
+var javaAccessComments = map[string]string{
+	ReadOnly:  "Read only, ignored by the server.",
+	WriteOnce: "Can only be set when creating.",
+	Generated: "Generated by the server.",
+	WriteOnly: "Write only, never returned by the server.",
+}
+
 func (d DocType) javaTypeFor(
 	javaClasses map[string]string,
 	t reflect.Type,
 	pkg, meth string,
 	tag reflect.StructTag) (string, error) {
 
+	if mapping, found := typeMappingFor(t); found && mapping.Java != "" {
+		return mapping.Java, nil
+	}
 	switch t {
 	case durationType:
 		if tag.Get("ticker") != "" {
@@ -165,98 +333,270 @@ func (d DocType) javaTypeFor(
 		} else {
 			return "Long", nil
 		}
-	default:
-		switch t.Kind() {
-		case reflect.Ptr:
-			if t == keyType {
-				return "String", nil
-			} else if t.Elem().Kind() == reflect.Struct {
-				dt, err := NewDocType(t.Elem(), meth)
-				if err != nil {
-					return "", err
-				}
-				if err := dt.populateJavaClasses(javaClasses, pkg, meth); err != nil {
-					return "", err
-				}
-				return t.Elem().Name(), nil
-			} else {
-				return "", fmt.Errorf("%+v found untranslatable Go Type %v", d, t)
-			}
-		case reflect.Map:
-			javaKey, err := d.javaTypeFor(javaClasses, t.Key(), pkg, meth, "")
-			if err != nil {
-				return "", err
-			}
-			javaVal, err := d.javaTypeFor(javaClasses, t.Elem(), pkg, meth, "")
-			if err != nil {
-				return "", err
-			}
-			return fmt.Sprintf("Map<%s,%s>", javaKey, javaVal), nil
-		case reflect.Bool:
-			return "Boolean", nil
-		case reflect.String:
+	case keyType:
+		return "String", nil
+	case timeType:
+		return "java.util.Date", nil
+	}
+	if t.Kind() != reflect.Ptr && t.Kind() != reflect.Interface {
+		if implements(t, jsonMarshalerType) {
+			return "com.google.gson.JsonElement", nil
+		}
+		if implements(t, textMarshalerType) {
 			return "String", nil
-		case reflect.Struct:
-			if t == timeType {
-				return "java.util.Date", nil
-			}
-			dt, err := NewDocType(t, meth)
+		}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		if t.Elem().Kind() == reflect.Struct {
+			dt, err := NewDocType(t.Elem(), meth)
 			if err != nil {
 				return "", err
 			}
 			if err := dt.populateJavaClasses(javaClasses, pkg, meth); err != nil {
 				return "", err
 			}
-			return t.Name(), nil
-		case reflect.Slice:
-			javaElem, err := d.javaTypeFor(javaClasses, t.Elem(), pkg, meth, "")
-			if err != nil {
+			return t.Elem().Name(), nil
+		}
+		return d.javaTypeFor(javaClasses, t.Elem(), pkg, meth, tag)
+	case reflect.Interface:
+		if u, found := unions[t]; found {
+			if err := populateJavaUnion(javaClasses, u, pkg, meth); err != nil {
 				return "", err
 			}
-			return fmt.Sprintf("java.util.List<%s>", javaElem), nil
-		case reflect.Int64:
-			fallthrough
-		case reflect.Int32:
-			fallthrough
-		case reflect.Int:
-			return "Long", nil
-		case reflect.Float64:
-			return "Double", nil
+			return t.Name(), nil
+		}
+		return "Object", nil
+	case reflect.Map:
+		javaKey, err := d.javaTypeFor(javaClasses, t.Key(), pkg, meth, "")
+		if err != nil {
+			return "", err
+		}
+		javaVal, err := d.javaTypeFor(javaClasses, t.Elem(), pkg, meth, "")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Map<%s,%s>", javaKey, javaVal), nil
+	case reflect.Bool:
+		return "Boolean", nil
+	case reflect.String:
+		return "String", nil
+	case reflect.Struct:
+		dt, err := NewDocType(t, meth)
+		if err != nil {
+			return "", err
+		}
+		if err := dt.populateJavaClasses(javaClasses, pkg, meth); err != nil {
+			return "", err
+		}
+		return t.Name(), nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Base64 encoded, like encoding/json does.
+			return "String", nil
 		}
+		fallthrough
+	case reflect.Array:
+		javaElem, err := d.javaTypeFor(javaClasses, t.Elem(), pkg, meth, "")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("java.util.List<%s>", javaElem), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return "Long", nil
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return "java.math.BigInteger", nil
+	case reflect.Float32:
+		return "Float", nil
+	case reflect.Float64:
+		return "Double", nil
 	}
 	return "", fmt.Errorf("%+v found untranslatable Go Type %v", d, t)
 }
 
+// ToJSONSchema returns the JSON Schema of d. Named struct types are put in the definitions of
+// the returned schema and referred to using $ref, which also lets recursive types refer to themselves.
 func (d DocType) ToJSONSchema() (*JSONSchema, error) {
+	return d.cachedJSONSchema(false)
+}
+
+// ToInlineJSONSchema returns the JSON Schema of d without references, for consumers that don't
+// support them. Recursive types are cut off where they recur, and described as objects without properties.
+func (d DocType) ToInlineJSONSchema() (*JSONSchema, error) {
+	return d.cachedJSONSchema(true)
+}
+
+type jsonSchemaKey struct {
+	docTypeKey
+	inline bool
+}
+
+var (
+	jsonSchemas = sync.Map{}
+)
+
+func (d DocType) cachedJSONSchema(inline bool) (*JSONSchema, error) {
+	key := jsonSchemaKey{
+		docTypeKey: docTypeKey{typ: d.typ, method: d.method},
+		inline:     inline,
+	}
+	if cached, found := jsonSchemas.Load(key); found {
+		return cached.(*JSONSchema).clone(), nil
+	}
+	builder := newJSONSchemaBuilder(d.typ, inline)
+	result, err := builder.schema(&d)
+	if err != nil {
+		return nil, err
+	}
+	if len(builder.definitions) > 0 {
+		result.Definitions = builder.definitions
+	}
+	jsonSchemas.Store(key, result)
+	return result.clone(), nil
+}
+
+// clone returns a deep copy of s, so that callers can change it without changing the cached schemas.
+func (s *JSONSchema) clone() *JSONSchema {
+	if s == nil {
+		return nil
+	}
+	cpy := *s
+	cpy.Properties = cloneSchemas(s.Properties)
+	cpy.Definitions = cloneSchemas(s.Definitions)
+	cpy.Required = append([]string(nil), s.Required...)
+	cpy.AdditionalProperties = s.AdditionalProperties.clone()
+	cpy.Items = s.Items.clone()
+	if s.MinItems != nil {
+		minItems := *s.MinItems
+		cpy.MinItems = &minItems
+	}
+	if s.MaxItems != nil {
+		maxItems := *s.MaxItems
+		cpy.MaxItems = &maxItems
+	}
+	if s.Minimum != nil {
+		minimum := *s.Minimum
+		cpy.Minimum = &minimum
+	}
+	cpy.OneOf, cpy.AnyOf = nil, nil
+	for _, variant := range s.OneOf {
+		cpy.OneOf = append(cpy.OneOf, *variant.clone())
+	}
+	for _, alternative := range s.AnyOf {
+		cpy.AnyOf = append(cpy.AnyOf, *alternative.clone())
+	}
+	if s.Discriminator != nil {
+		discriminator := *s.Discriminator
+		if s.Discriminator.Mapping != nil {
+			discriminator.Mapping = map[string]string{}
+			for name, ref := range s.Discriminator.Mapping {
+				discriminator.Mapping[name] = ref
+			}
+		}
+		cpy.Discriminator = &discriminator
+	}
+	return &cpy
+}
+
+func cloneSchemas(schemas map[string]JSONSchema) map[string]JSONSchema {
+	if schemas == nil {
+		return nil
+	}
+	result := make(map[string]JSONSchema, len(schemas))
+	for name, schema := range schemas {
+		result[name] = *schema.clone()
+	}
+	return result
+}
+
+// jsonSchemaBuilder builds the JSON Schema of a DocType.
+type jsonSchemaBuilder struct {
+	inline bool
+	// root is the type of the schema being built, which is referred to as "#".
+	root        reflect.Type
+	definitions map[string]JSONSchema
+	names       map[reflect.Type]string
+	// building contains the struct types currently being described.
+	building map[reflect.Type]bool
+}
+
+func newJSONSchemaBuilder(root reflect.Type, inline bool) *jsonSchemaBuilder {
+	for root.Kind() == reflect.Ptr {
+		root = root.Elem()
+	}
+	return &jsonSchemaBuilder{
+		inline:      inline,
+		root:        root,
+		definitions: map[string]JSONSchema{},
+		names:       map[reflect.Type]string{},
+		building:    map[reflect.Type]bool{},
+	}
+}
+
+// definitionName returns the name of typ in the definitions, qualifying it with its package if needed.
+func (b *jsonSchemaBuilder) definitionName(typ reflect.Type) string {
+	if name, found := b.names[typ]; found {
+		return name
+	}
+	name := typ.Name()
+	for otherType, otherName := range b.names {
+		if otherName == name && otherType != typ {
+			name = strings.NewReplacer("/", "_", ".", "_").Replace(typ.PkgPath()) + "_" + typ.Name()
+			break
+		}
+	}
+	b.names[typ] = name
+	return name
+}
+
+func (b *jsonSchemaBuilder) schema(d *DocType) (*JSONSchema, error) {
+	if mapping, found := typeMappingFor(d.typ); found && mapping.JSONSchema != nil {
+		return mapping.JSONSchema(), nil
+	}
 	schemaType := &JSONSchema{}
-	switch d.typ.Kind() {
-	case reflect.Ptr:
-		if d.typ == keyType {
+	switch d.typ {
+	case keyType:
+		schemaType.Type = "string"
+		schemaType.Nullable = true
+		return schemaType, nil
+	case timeType:
+		schemaType.Type = "datetime"
+		return schemaType, nil
+	case durationType:
+		schemaType.Type = "integer"
+		return schemaType, nil
+	}
+	if d.typ.Kind() != reflect.Ptr && d.typ.Kind() != reflect.Interface {
+		if implements(d.typ, jsonMarshalerType) {
+			// Could be anything.
+			return schemaType, nil
+		}
+		if implements(d.typ, textMarshalerType) {
 			schemaType.Type = "string"
-		} else if d.typ.Elem().Kind() == reflect.Struct {
-			schemaType.Type = "object"
-			schemaType.Properties = map[string]JSONSchema{}
-			fields, err := NewDocFields(d.typ.Elem(), d.method)
-			if err != nil {
-				return nil, err
-			}
-			for _, field := range fields {
-				s, err := field.ToJSONSchema()
-				if err != nil {
-					return nil, err
-				}
-				schemaType.Properties[field.Name] = *s
-			}
-		} else {
-			return nil, fmt.Errorf("%+v is untranslatable Go Type %v", d, d.typ)
+			return schemaType, nil
 		}
-	case reflect.Map:
-		schemaType.Type = "object"
-		valueDocType, err := NewDocType(d.typ.Elem(), d.method)
+	}
+	switch d.typ.Kind() {
+	case reflect.Ptr:
+		elemType, err := b.schema(d.Elem)
 		if err != nil {
 			return nil, err
 		}
-		valueType, err := valueDocType.ToJSONSchema()
+		if elemType.Ref != "" {
+			// References can't be combined with other keywords, so accept null as an alternative.
+			schemaType.AnyOf = []JSONSchema{*elemType, {Type: "null"}}
+			break
+		}
+		schemaType = elemType
+		schemaType.Nullable = schemaType.Type != ""
+	case reflect.Interface:
+		if d.Variants != nil {
+			return b.unionSchema(d)
+		}
+	case reflect.Map:
+		schemaType.Type = "object"
+		valueType, err := b.schema(d.Elem)
 		if err != nil {
 			return nil, err
 		}
@@ -266,34 +606,36 @@ func (d DocType) ToJSONSchema() (*JSONSchema, error) {
 	case reflect.String:
 		schemaType.Type = "string"
 	case reflect.Struct:
-		switch d.typ {
-		case timeType:
-			schemaType.Type = "datetime"
-		default:
-			schemaType.Type = "object"
-			schemaType.Properties = map[string]JSONSchema{}
-			for _, field := range d.Fields {
-				s, err := field.ToJSONSchema()
-				if err != nil {
-					return nil, err
-				}
-				schemaType.Properties[field.Name] = *s
-			}
-		}
+		return b.structSchema(d)
 	case reflect.Slice:
+		if d.typ.Elem().Kind() == reflect.Uint8 {
+			schemaType.Type = "string"
+			schemaType.ContentEncoding = "base64"
+			break
+		}
 		schemaType.Type = "array"
-		elType, err := d.Elem.ToJSONSchema()
+		elType, err := b.schema(d.Elem)
 		if err != nil {
 			return nil, err
 		}
 		schemaType.Items = elType
-	case reflect.Int64:
-		fallthrough
-	case reflect.Int32:
-		fallthrough
-	case reflect.Int:
+	case reflect.Array:
+		schemaType.Type = "array"
+		elType, err := b.schema(d.Elem)
+		if err != nil {
+			return nil, err
+		}
+		schemaType.Items = elType
+		length := d.typ.Len()
+		schemaType.MinItems = &length
+		schemaType.MaxItems = &length
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		schemaType.Type = "integer"
-	case reflect.Float64:
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		schemaType.Type = "integer"
+		minimum := 0.0
+		schemaType.Minimum = &minimum
+	case reflect.Float32, reflect.Float64:
 		schemaType.Type = "number"
 	default:
 		return nil, fmt.Errorf("%+v is untranslatable Go Type %v", d, d.typ)
@@ -301,104 +643,430 @@ func (d DocType) ToJSONSchema() (*JSONSchema, error) {
 	return schemaType, nil
 }
 
-type DocField struct {
-	Name  string
-	Type  *DocType
-	field reflect.StructField
+// unionSchema describes d as one of its variants, picked using its discriminator.
+func (b *jsonSchemaBuilder) unionSchema(d *DocType) (*JSONSchema, error) {
+	schemaType := &JSONSchema{
+		Discriminator: &JSONSchemaDiscriminator{
+			PropertyName: d.Discriminator,
+		},
+	}
+	names := make([]string, 0, len(d.Variants))
+	for name := range d.Variants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		variantType, err := b.schema(d.Variants[name])
+		if err != nil {
+			return nil, err
+		}
+		if variantType.Ref != "" {
+			if schemaType.Discriminator.Mapping == nil {
+				schemaType.Discriminator.Mapping = map[string]string{}
+			}
+			schemaType.Discriminator.Mapping[name] = variantType.Ref
+		}
+		schemaType.OneOf = append(schemaType.OneOf, *variantType)
+	}
+	return schemaType, nil
 }
 
-func (d DocField) ToJSONSchema() (*JSONSchema, error) {
-	typ, err := d.Type.ToJSONSchema()
+func (b *jsonSchemaBuilder) structSchema(d *DocType) (*JSONSchema, error) {
+	named := d.typ.Name() != ""
+	if b.building[d.typ] {
+		if b.inline {
+			return &JSONSchema{Type: "object"}, nil
+		}
+		if d.typ == b.root {
+			return &JSONSchema{Ref: "#"}, nil
+		}
+		return &JSONSchema{Ref: "#/definitions/" + b.definitionName(d.typ)}, nil
+	}
+	if !b.inline && named && d.typ != b.root {
+		name := b.definitionName(d.typ)
+		if _, found := b.definitions[name]; !found {
+			definition, err := b.objectSchema(d)
+			if err != nil {
+				return nil, err
+			}
+			b.definitions[name] = *definition
+		}
+		return &JSONSchema{Ref: "#/definitions/" + name}, nil
+	}
+	return b.objectSchema(d)
+}
+
+func (b *jsonSchemaBuilder) objectSchema(d *DocType) (*JSONSchema, error) {
+	b.building[d.typ] = true
+	defer delete(b.building, d.typ)
+	schemaType := &JSONSchema{
+		Type:       "object",
+		Properties: map[string]JSONSchema{},
+	}
+	for _, field := range d.Fields {
+		s, err := b.fieldSchema(&field)
+		if err != nil {
+			return nil, err
+		}
+		schemaType.Properties[field.Name] = *s
+		// Responses always contain the fields without omitempty, unless they are in a nil embedded pointer.
+		if d.method == "GET" && !field.OmitEmpty && !field.inEmbeddedPointer(d.typ) {
+			schemaType.Required = append(schemaType.Required, field.Name)
+		}
+	}
+	return schemaType, nil
+}
+
+func (b *jsonSchemaBuilder) fieldSchema(d *DocField) (*JSONSchema, error) {
+	typ, err := b.schema(d.Type)
 	if err != nil {
 		return nil, err
 	}
 	typ.Title = d.Name
+	if d.String {
+		typ.Type = "string"
+	}
+	switch d.Access {
+	case ReadOnly, Generated:
+		typ.ReadOnly = true
+	case WriteOnly:
+		typ.WriteOnly = true
+	}
 	return typ, nil
 }
 
-func NewDocFields(typ reflect.Type, method string) ([]DocField, error) {
-	result := []DocField{}
-	for i := 0; i < typ.NumField(); i++ {
-		field := typ.Field(i)
-		found := false
-		if method == "GET" || method == "" {
-			found = field.Tag.Get("json") != "-"
-		} else {
-			methods := strings.Split(field.Tag.Get("methods"), ",")
-			for j := 0; j < len(methods); j++ {
-				if methods[j] == method {
-					found = true
-					break
+type DocField struct {
+	// Name is the name of the field in JSON.
+	Name string
+	Type *DocType
+	// OmitEmpty and String are set if the field has the omitempty and string options in its `json` tag.
+	// Fields without OmitEmpty are required in GET JSON Schemas, since responses always contain them.
+	OmitEmpty bool
+	String    bool
+	// Access is the `access` tag of the field, one of ReadOnly, WriteOnce, Generated, WriteOnly or empty.
+	Access string
+	field  reflect.StructField
+	// index is the index sequence of the field in the type it was found in, including embedded structs.
+	index []int
+}
+
+// lookup returns the field in val, which must be a struct of the type the field was found in.
+// It returns false if the field is inside a nil embedded struct pointer.
+func (d DocField) lookup(val reflect.Value) (reflect.Value, bool) {
+	for i, idx := range d.index {
+		if i > 0 && val.Kind() == reflect.Ptr {
+			if val.IsNil() {
+				return reflect.Value{}, false
+			}
+			val = val.Elem()
+		}
+		val = val.Field(idx)
+	}
+	return val, true
+}
+
+// inEmbeddedPointer returns whether the field is inside an embedded struct pointer of typ,
+// the type the field was found in.
+func (d DocField) inEmbeddedPointer(typ reflect.Type) bool {
+	for _, idx := range d.index[:len(d.index)-1] {
+		if typ = typ.Field(idx).Type; typ.Kind() == reflect.Ptr {
+			return true
+		}
+	}
+	return false
+}
+
+// value returns the field in val, which must be a struct of the type the field was found in.
+// Nil embedded struct pointers on the way are allocated.
+func (d DocField) value(val reflect.Value) (reflect.Value, error) {
+	for i, idx := range d.index {
+		if i > 0 && val.Kind() == reflect.Ptr {
+			if val.IsNil() {
+				if !val.CanSet() {
+					return reflect.Value{}, fmt.Errorf("embedded pointer to unexported struct %v is nil", val.Type().Elem())
 				}
+				val.Set(reflect.New(val.Type().Elem()))
 			}
+			val = val.Elem()
 		}
-		if found {
-			if field.Anonymous {
-				f, err := NewDocFields(field.Type, method)
-				if err != nil {
-					return nil, err
+		val = val.Field(idx)
+	}
+	return val, nil
+}
+
+func (d DocField) ToJSONSchema() (*JSONSchema, error) {
+	builder := newJSONSchemaBuilder(d.Type.typ, false)
+	result, err := builder.fieldSchema(&d)
+	if err != nil {
+		return nil, err
+	}
+	if len(builder.definitions) > 0 {
+		result.Definitions = builder.definitions
+	}
+	return result, nil
+}
+
+// jsonTag returns the name of field in JSON, and whether it has the omitempty and string options.
+// An empty name means the field has no JSON name of its own.
+func jsonTag(field reflect.StructField) (name string, omitEmpty, asString bool) {
+	parts := strings.Split(field.Tag.Get("json"), ",")
+	name = parts[0]
+	if name == "-" && len(parts) == 1 {
+		name = ""
+	}
+	for _, opt := range parts[1:] {
+		switch opt {
+		case "omitempty":
+			omitEmpty = true
+		case "string":
+			asString = true
+		}
+	}
+	return name, omitEmpty, asString
+}
+
+// stringable returns whether encoding/json honors the string option for typ.
+func stringable(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.String:
+		return true
+	}
+	return false
+}
+
+// docTypeKey identifies a DocType in the docTypes cache.
+type docTypeKey struct {
+	typ    reflect.Type
+	method string
+}
+
+var (
+	// docTypes caches complete DocTypes, which are immutable once built.
+	docTypes = sync.Map{}
+)
+
+func NewDocFields(typ reflect.Type, method string) ([]DocField, error) {
+	return newDocFields(typ, method, map[docTypeKey]*DocType{})
+}
+
+// newDocFields returns the fields of typ, using building for DocTypes currently being built to break cycles.
+func newDocFields(typ reflect.Type, method string, building map[docTypeKey]*DocType) ([]DocField, error) {
+	// Like encoding/json, walk the embedded structs breadth first so that shallower
+	// fields can shadow deeper ones. Which field wins a name is decided among all fields,
+	// before leaving out the ones not part of the DocType for method.
+	type embedding struct {
+		typ   reflect.Type
+		index []int
+		// found is whether the embedded structs on the way are part of the DocType for method.
+		found bool
+	}
+	type candidate struct {
+		field     reflect.StructField
+		index     []int
+		name      string
+		tagged    bool
+		omitEmpty bool
+		asString  bool
+		access    string
+		found     bool
+	}
+	candidates := []candidate{}
+	next := []embedding{{typ: typ, found: true}}
+	visited := map[reflect.Type]bool{}
+	for len(next) > 0 {
+		current := next
+		next = nil
+		for _, e := range current {
+			if visited[e.typ] {
+				continue
+			}
+			visited[e.typ] = true
+			for i := 0; i < e.typ.NumField(); i++ {
+				field := e.typ.Field(i)
+				embedded := field.Type
+				if embedded.Kind() == reflect.Ptr {
+					embedded = embedded.Elem()
+				}
+				// Like encoding/json, ignore unexported fields, except embedded structs and struct pointers.
+				if field.PkgPath != "" && (!field.Anonymous || embedded.Kind() != reflect.Struct) {
+					continue
+				}
+				if field.Tag.Get("json") == "-" {
+					continue
 				}
-				result = append(result, f...)
-			} else {
-				d, err := NewDocType(field.Type, method)
+				access, found, err := fieldFound(e.typ, field, method)
 				if err != nil {
 					return nil, err
 				}
-				result = append(result, DocField{
-					Name:  field.Name,
-					Type:  d,
-					field: field,
+				index := append(append([]int{}, e.index...), i)
+				name, omitEmpty, asString := jsonTag(field)
+				if field.Anonymous && embedded.Kind() == reflect.Struct && name == "" {
+					next = append(next, embedding{typ: embedded, index: index, found: e.found && found})
+					continue
+				}
+				tagged := name != ""
+				if !tagged {
+					name = field.Name
+				}
+				candidates = append(candidates, candidate{
+					field:     field,
+					index:     index,
+					name:      name,
+					tagged:    tagged,
+					omitEmpty: omitEmpty,
+					asString:  asString,
+					access:    access,
+					found:     e.found && found,
 				})
 			}
 		}
 	}
+	// Of the fields with the same name, the shallowest wins, and of the equally shallow the tagged one.
+	// Like encoding/json, names without a single winner are left out.
+	byName := map[string][]candidate{}
+	for _, c := range candidates {
+		byName[c.name] = append(byName[c.name], c)
+	}
+	result := []DocField{}
+	for name, named := range byName {
+		sort.SliceStable(named, func(i, j int) bool {
+			if len(named[i].index) != len(named[j].index) {
+				return len(named[i].index) < len(named[j].index)
+			}
+			return named[i].tagged && !named[j].tagged
+		})
+		if len(named) > 1 && len(named[0].index) == len(named[1].index) && named[0].tagged == named[1].tagged {
+			continue
+		}
+		winner := named[0]
+		if !winner.found {
+			continue
+		}
+		d, err := newDocType(winner.field.Type, method, building)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, DocField{
+			Name:      name,
+			Type:      d,
+			Access:    winner.access,
+			OmitEmpty: winner.omitEmpty,
+			String:    winner.asString && stringable(winner.field.Type),
+			field:     winner.field,
+			index:     winner.index,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i].index, result[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
 	return result, nil
 }
 
+// fieldFound returns the access of field in typ, and whether it's part of the DocType for method.
+func fieldFound(typ reflect.Type, field reflect.StructField, method string) (string, bool, error) {
+	access := field.Tag.Get("access")
+	switch access {
+	case "", ReadOnly, WriteOnce, Generated, WriteOnly:
+	default:
+		return "", false, fmt.Errorf("%v.%v has unknown access %q", typ, field.Name, access)
+	}
+	switch {
+	case method == "":
+		return access, field.Tag.Get("json") != "-", nil
+	case method == "GET":
+		return access, field.Tag.Get("json") != "-" && access != WriteOnly, nil
+	case access == ReadOnly || access == Generated:
+		return access, false, nil
+	case access == WriteOnce:
+		return access, method == "POST", nil
+	}
+	for _, m := range strings.Split(field.Tag.Get("methods"), ",") {
+		if m == method {
+			return access, true, nil
+		}
+	}
+	return access, false, nil
+}
+
+// NewDocType returns the DocType of typ for method. DocTypes are cached and shared, and
+// the DocTypes of recursive types refer to themselves.
 func NewDocType(typ reflect.Type, method string) (*DocType, error) {
+	key := docTypeKey{typ: typ, method: method}
+	if cached, found := docTypes.Load(key); found {
+		return cached.(*DocType), nil
+	}
+	building := map[docTypeKey]*DocType{}
+	if _, err := newDocType(typ, method, building); err != nil {
+		return nil, err
+	}
+	for buildingKey, docType := range building {
+		docTypes.LoadOrStore(buildingKey, docType)
+	}
+	cached, _ := docTypes.Load(key)
+	return cached.(*DocType), nil
+}
+
+func newDocType(typ reflect.Type, method string, building map[docTypeKey]*DocType) (*DocType, error) {
+	key := docTypeKey{typ: typ, method: method}
+	if cached, found := docTypes.Load(key); found {
+		return cached.(*DocType), nil
+	}
+	if result, found := building[key]; found {
+		return result, nil
+	}
 	result := &DocType{
 		Name:   typ.String(),
 		Kind:   typ.Kind().String(),
 		typ:    typ,
 		method: method,
 	}
+	building[key] = result
 	switch typ.Kind() {
 	case reflect.Struct:
 		var err error
-		result.Fields, err = NewDocFields(typ, method)
+		result.Fields, err = newDocFields(typ, method, building)
 		if err != nil {
 			return nil, err
 		}
-	case reflect.Slice:
-		elem, err := NewDocType(typ.Elem(), method)
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		// Keys aren't documented, and their internals would recurse for no benefit.
+		if typ == keyType {
+			break
+		}
+		elem, err := newDocType(typ.Elem(), method, building)
 		if err != nil {
 			return nil, err
 		}
 		result.Elem = elem
+	case reflect.Interface:
+		if u, found := unions[typ]; found {
+			result.Discriminator = u.Discriminator
+			result.Variants = map[string]*DocType{}
+			for name, variant := range u.Variants {
+				if variant.Kind() == reflect.Ptr {
+					variant = variant.Elem()
+				}
+				variantDocType, err := newDocType(variant, method, building)
+				if err != nil {
+					return nil, err
+				}
+				result.Variants[name] = variantDocType
+			}
+		}
 	}
 	return result, nil
 }
 
 func copyJSON(dest interface{}, b []byte, method string) error {
-	decoded := map[string]interface{}{}
-	if err := json.Unmarshal(b, &decoded); err != nil {
-		return err
-	}
-	val := reflect.ValueOf(dest)
-	if val.Kind() != reflect.Ptr {
-		return fmt.Errorf("can only copy to pointer to struct")
-	}
-	val = val.Elem()
-	if val.Kind() != reflect.Struct {
-		return fmt.Errorf("can only copy to pointer to struct")
-	}
-	typ := val.Type()
-	if err := filterJSON(typ, decoded, method); err != nil {
-		return err
-	}
-	filtered, err := json.Marshal(decoded)
-	if err != nil {
-		return err
-	}
-	return json.Unmarshal(filtered, dest)
+	return decodeJSON(dest, bytes.NewReader(b), method, false, false)
 }
@@ -0,0 +1,462 @@
+package goaeoas
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"golang.org/x/net/html"
+	"google.golang.org/appengine/v2/datastore"
+)
+
+const (
+	// MethodOverrideParam is the query parameter native HTML forms use to
+	// submit PUT and DELETE requests as POST requests.
+	MethodOverrideParam = "_method"
+)
+
+var (
+	formSubmissions bool
+)
+
+// SetFormSubmissions makes Copy accept application/x-www-form-urlencoded bodies, routes of other
+// methods reachable using POST with a MethodOverrideParam, and the browsable API render native
+// HTML forms for clients without JavaScript. Since browsers send such requests across sites
+// without asking, it panics unless CSRF protection is enabled using SetCSRF.
+func SetFormSubmissions(enabled bool) {
+	if enabled && csrf == nil {
+		panic("form submissions require CSRF protection, call SetCSRF first")
+	}
+	formSubmissions = enabled
+}
+
+func init() {
+	// Form fields are named like the JSON fields in the DocTypes.
+	schemaDecoder.SetAliasTag("json")
+	schemaDecoder.IgnoreUnknownKeys(true)
+	schemaDecoder.ZeroEmpty(true)
+	schemaDecoder.RegisterConverter(time.Time{}, func(s string) reflect.Value {
+		if s == "" {
+			return reflect.ValueOf(time.Time{})
+		}
+		for _, format := range []string{DateTimeInputFormat, time.RFC3339} {
+			if t, err := time.Parse(format, s); err == nil {
+				return reflect.ValueOf(t)
+			}
+		}
+		return reflect.Value{}
+	})
+	schemaDecoder.RegisterConverter(datastore.Key{}, func(s string) reflect.Value {
+		k, err := datastore.DecodeKey(s)
+		if err != nil {
+			return reflect.Value{}
+		}
+		return reflect.ValueOf(*k)
+	})
+}
+
+// ValidationErr reports invalid field values, keyed by field path.
+// When returned for an HTML form submission, the form is rendered again
+// with the messages next to the offending fields.
+type ValidationErr struct {
+	Fields map[string]string
+}
+
+func (v ValidationErr) Error() string {
+	keys := make([]string, 0, len(v.Fields))
+	for key := range v.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s: %s", key, v.Fields[key]))
+	}
+	return fmt.Sprintf("invalid fields: %s", strings.Join(parts, ", "))
+}
+
+func isFormSubmission(r *http.Request) bool {
+	if !formSubmissions || r.Method == "GET" {
+		return false
+	}
+	media, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return media == "application/x-www-form-urlencoded"
+}
+
+// registerMethodOverrides lets native HTML forms, which only support GET and POST,
+// reach the routes of the other methods by POSTing with a MethodOverrideParam query parameter,
+// when enabled using SetFormSubmissions.
+func registerMethodOverrides(ro *mux.Router, route *mux.Route, pattern string, methods []string, handler http.HandlerFunc) {
+	for _, method := range methods {
+		if method == "POST" {
+			route.MatcherFunc(func(r *http.Request, m *mux.RouteMatch) bool {
+				return !formSubmissions || r.URL.Query().Get(MethodOverrideParam) == ""
+			})
+		}
+	}
+	for _, method := range methods {
+		if method == "GET" || method == "POST" {
+			continue
+		}
+		overridden := method
+		ro.Path(pattern).Methods("POST").Queries(MethodOverrideParam, overridden).MatcherFunc(func(r *http.Request, m *mux.RouteMatch) bool {
+			return formSubmissions
+		}).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Method = overridden
+			handler(w, r)
+		})
+	}
+}
+
+func copyForm(dest interface{}, values url.Values, method string, strict bool) error {
+	val := reflect.ValueOf(dest)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("can only copy to pointer to struct")
+	}
+	docType, err := NewDocType(val.Elem().Type(), method)
+	if err != nil {
+		return err
+	}
+	filtered := url.Values{}
+	rejected := []RejectedField{}
+	for key, vals := range values {
+		path := strings.Split(key, ".")
+		fieldType := docType.formField(path)
+		if fieldType == nil {
+			// Fields starting with _ are used by the framework, e.g. for method overrides.
+			if strict && !strings.HasPrefix(key, "_") {
+				reason := NotWritableField
+				if allFields, err := NewDocType(docType.typ, ""); err != nil {
+					return err
+				} else if allFields.formField(path) == nil {
+					reason = UnknownField
+				}
+				rejected = append(rejected, RejectedField{
+					Path:   key,
+					Reason: reason,
+				})
+			}
+			continue
+		}
+		if kind := fieldType.typ.Kind(); kind == reflect.Slice || kind == reflect.Ptr {
+			nonEmpty := []string{}
+			for _, v := range vals {
+				if v != "" {
+					nonEmpty = append(nonEmpty, v)
+				}
+			}
+			vals = nonEmpty
+		}
+		if len(vals) > 0 || fieldType.typ.Kind() == reflect.Slice {
+			filtered[key] = vals
+		}
+	}
+	if len(rejected) > 0 {
+		sort.Slice(rejected, func(i, j int) bool {
+			return rejected[i].Path < rejected[j].Path
+		})
+		return RejectedFieldsErr{
+			Method: method,
+			Fields: rejected,
+		}
+	}
+	if err := schemaDecoder.Decode(dest, filtered); err != nil {
+		if merr, ok := err.(schema.MultiError); ok {
+			verr := ValidationErr{
+				Fields: map[string]string{},
+			}
+			for key, err := range merr {
+				verr.Fields[key] = err.Error()
+			}
+			return verr
+		}
+		return err
+	}
+	return nil
+}
+
+// formField returns the DocType at path, or nil if path doesn't point to a field writable using native HTML forms.
+func (d *DocType) formField(path []string) *DocType {
+	if len(path) == 0 {
+		return d
+	}
+	if d.typ.Kind() == reflect.Ptr {
+		elem, err := elemDocType(d)
+		if err != nil {
+			return nil
+		}
+		return elem.formField(path)
+	}
+	if d.typ.Kind() == reflect.Slice && len(d.Elem.Fields) > 0 {
+		if _, err := strconv.Atoi(path[0]); err != nil {
+			return nil
+		}
+		return d.Elem.formField(path[1:])
+	}
+	field, found := d.GetField(path[0])
+	if !found {
+		return nil
+	}
+	return field.Type.formField(path[1:])
+}
+
+// FormValues returns the fields of properties writable using method, encoded the way native HTML forms would submit them.
+func FormValues(properties interface{}, method string) (url.Values, error) {
+	val := reflect.ValueOf(properties)
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return url.Values{}, nil
+		}
+		val = val.Elem()
+	}
+	docType, err := NewDocType(val.Type(), method)
+	if err != nil {
+		return nil, err
+	}
+	result := url.Values{}
+	addFormValues(result, "", val, docType)
+	return result, nil
+}
+
+func addFormValues(values url.Values, prefix string, val reflect.Value, docType *DocType) {
+	for _, field := range docType.Fields {
+		if field.Access == WriteOnly {
+			continue
+		}
+		name := prefix + field.Name
+		fieldVal, found := field.lookup(val)
+		if !found {
+			continue
+		}
+		if isFormStruct(field.Type) {
+			addFormValues(values, name+".", fieldVal, field.Type)
+		} else if field.Type.typ.Kind() == reflect.Slice {
+			for i := 0; i < fieldVal.Len(); i++ {
+				if s, ok := formValue(fieldVal.Index(i)); ok {
+					values.Add(name, s)
+				}
+			}
+		} else if s, ok := formValue(fieldVal); ok {
+			values.Set(name, s)
+		}
+	}
+}
+
+func formValue(val reflect.Value) (string, bool) {
+	switch val.Type() {
+	case keyType:
+		if val.IsNil() {
+			return "", true
+		}
+		return val.Interface().(*datastore.Key).Encode(), true
+	case timeType:
+		t := val.Interface().(time.Time)
+		if t.IsZero() {
+			return "", true
+		}
+		return t.Format(DateTimeInputFormat), true
+	}
+	switch val.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return fmt.Sprint(val.Interface()), true
+	}
+	return "", false
+}
+
+func isFormStruct(d *DocType) bool {
+	return d.typ.Kind() == reflect.Struct && d.typ != timeType
+}
+
+// FormNode renders a native HTML form for the link in v, prefilled with v.Values and
+// with v.FieldErrors next to the fields they concern.
+// Fields that can't be edited using native forms, like maps and slices of structs, are left out.
+func FormNode(v *LinkView) *Node {
+	action, err := url.Parse(safeURL(v.URL))
+	if err != nil {
+		action = &url.URL{Path: v.URL}
+	}
+	if v.Method != "POST" {
+		query := action.Query()
+		query.Set(MethodOverrideParam, v.Method)
+		action.RawQuery = query.Encode()
+	}
+	formNode := NewEl("form", "method", "post", "action", action.String(), "class", "native", "enctype", "application/x-www-form-urlencoded")
+	if v.CSRFToken != "" {
+		formNode.AddEl("input", "type", "hidden", "name", CSRFField, "value", v.CSRFToken)
+	}
+	if v.DocType != nil {
+		addFormInputs(formNode, "", v.DocType, v)
+	}
+	formNode.AddEl("input", "type", "submit", "value", v.Link.Rel)
+	return formNode
+}
+
+func addFormInputs(parent *Node, prefix string, docType *DocType, v *LinkView) {
+	for _, field := range docType.Fields {
+		name := prefix + field.Name
+		if isFormStruct(field.Type) {
+			fieldsetNode := NewEl("fieldset")
+			fieldsetNode.AddEl("legend").AddText(field.Name)
+			addFormInputs(fieldsetNode, name+".", field.Type, v)
+			if fieldsetNode.FirstChild != fieldsetNode.LastChild {
+				parent.AddNode(fieldsetNode)
+			}
+			continue
+		}
+		inputNode := formInput(field.Type, name, v.Values[name])
+		if inputNode == nil {
+			continue
+		}
+		labelNode := parent.AddEl("label")
+		labelNode.AddText(field.Name)
+		labelNode.AddNode(inputNode)
+		if msg, found := v.FieldErrors[name]; found {
+			labelNode.AddEl("span", "class", "error").AddText(msg)
+		}
+	}
+}
+
+func formInput(docType *DocType, name string, values []string) *Node {
+	typ := docType.typ
+	if typ.Kind() == reflect.Slice && typ.Elem().Kind() != reflect.Uint8 {
+		elemDocType := docType.Elem
+		if elemDocType == nil || isFormStruct(elemDocType) {
+			return nil
+		}
+		inputsNode := NewEl("span", "class", "inputs")
+		for _, value := range append(values, "") {
+			inputNode := formInput(elemDocType, name, []string{value})
+			if inputNode == nil {
+				return nil
+			}
+			inputsNode.AddNode(inputNode)
+		}
+		return inputsNode
+	}
+	value := ""
+	if len(values) > 0 {
+		value = values[len(values)-1]
+	}
+	switch typ {
+	case keyType:
+		return NewEl("input", "type", "text", "name", name, "value", value)
+	case timeType:
+		return NewEl("input", "type", "datetime-local", "name", name, "value", value)
+	case durationType:
+		return NewEl("input", "type", "number", "step", "1", "name", name, "value", value, "title", "nanoseconds")
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		spanNode := NewEl("span")
+		spanNode.AddEl("input", "type", "hidden", "name", name, "value", "false")
+		checkbox := spanNode.AddEl("input", "type", "checkbox", "name", name, "value", "true")
+		if value == "true" {
+			checkbox.Attr = append(checkbox.Attr, html.Attribute{Key: "checked", Val: "checked"})
+		}
+		return spanNode
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return NewEl("input", "type", "number", "step", "1", "name", name, "value", value)
+	case reflect.Float32, reflect.Float64:
+		return NewEl("input", "type", "number", "step", "any", "name", name, "value", value)
+	case reflect.String:
+		return NewEl("input", "type", "text", "name", name, "value", value)
+	}
+	return nil
+}
+
+// formErrorContent renders the form of the current route again, with the submitted values and the error.
+func formErrorContent(r *request, routeName string, err error) (Content, int, bool) {
+	body, status := errorStatus(err)
+	if _, ok := err.(ValidationErr); !ok && status != 400 && status != 409 && status != 422 {
+		return nil, 0, false
+	}
+	routeParams := []string{}
+	for key, val := range r.vars {
+		routeParams = append(routeParams, key, val)
+	}
+	link := r.NewLink(Link{
+		Rel:         routeName,
+		Route:       routeName,
+		RouteParams: routeParams,
+		Method:      r.req.Method,
+	})
+	if opts, found := routeOpts[routeName]; found {
+		link.Type = opts.bodyType
+	}
+	view, lerr := newLinkView(&link)
+	if lerr != nil {
+		return nil, 0, false
+	}
+	view.Values = r.formValues
+	if verr, ok := err.(ValidationErr); ok {
+		view.FieldErrors = verr.Fields
+	}
+	return &formError{
+		view: view,
+		body: body,
+	}, status, true
+}
+
+type formError struct {
+	view *LinkView
+	body string
+}
+
+func (f *formError) HTMLNode() (*Node, error) {
+	sectionNode := NewEl("section", "class", "error")
+	sectionNode.AddEl("header").AddText("Error")
+	sectionNode.AddEl("p").AddText(f.body)
+	linkNode, err := theme.Link(f.view)
+	if err != nil {
+		return nil, err
+	}
+	sectionNode.AddEl("nav").AddNode(linkNode)
+	return sectionNode, nil
+}
+
+type formDone struct {
+	back string
+}
+
+func (f formDone) HTMLNode() (*Node, error) {
+	sectionNode := NewEl("section")
+	sectionNode.AddEl("header").AddText("Done")
+	if f.back != "" {
+		sectionNode.AddEl("nav").AddEl("a", "href", safeURL(f.back)).AddText("back")
+	}
+	return sectionNode, nil
+}
+
+// redirectAfterForm implements Post/Redirect/Get for HTML form submissions by redirecting to the self link of the resulting Item.
+// If there is no self link, a short confirmation is rendered instead.
+func redirectAfterForm(w *responseWriter, r *request) error {
+	if item, ok := w.content.(*Item); ok {
+		for _, link := range item.Links {
+			if link.Rel == "self" {
+				u, err := link.Resolve()
+				if err != nil {
+					return err
+				}
+				w.content = nil
+				http.Redirect(w.ResponseWriter, r.req, u, http.StatusSeeOther)
+				return nil
+			}
+		}
+	}
+	w.content = formDone{
+		back: r.req.Header.Get("Referer"),
+	}
+	return nil
+}
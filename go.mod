@@ -1,12 +1,19 @@
 module github.com/zond/goaeoas
 
-go 1.14
+go 1.21
 
 require (
 	github.com/davecgh/go-spew v1.1.1
 	github.com/gorilla/mux v1.7.4
 	github.com/gorilla/schema v1.1.0
 	github.com/kr/pretty v0.2.0
-	golang.org/x/net v0.0.0-20200226121028-0de0cce0169b
-	google.golang.org/appengine v1.6.5
+	golang.org/x/net v0.0.0-20220722155237-a158d28d115b
+	google.golang.org/appengine/v2 v2.0.6
+)
+
+require (
+	github.com/golang/protobuf v1.5.0 // indirect
+	github.com/google/go-cmp v0.6.0 // indirect
+	github.com/kr/text v0.1.0 // indirect
+	google.golang.org/protobuf v1.30.0 // indirect
 )
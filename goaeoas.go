@@ -1,10 +1,13 @@
 package goaeoas
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"mime"
 	"net/http"
 	"net/url"
@@ -24,6 +27,7 @@ var (
 	postProcs     []func(ResponseWriter, Request, error) (bool, error)
 	schemaDecoder = schema.NewDecoder()
 	nextElementID uint64
+	maxBodySize   int64
 	headCallbacks []func(*Node) error
 	jsonFormURL   *url.URL
 	jsvURL        *url.URL
@@ -58,11 +62,11 @@ func (h HTTPErr) Error() string {
 
 func HTTPError(w http.ResponseWriter, r *http.Request, err error) {
 	media, _ := Media(r, "Accept")
-	handleError(w, media, err)
+	handleError(r.Context(), w, media, err)
 }
 
-func httpError(w http.ResponseWriter, media, body string, status int) {
-	log.Printf("Returning %v; %v", status, body)
+func httpError(ctx context.Context, w http.ResponseWriter, media, body string, status int) {
+	logMsg(ctx, errorLogLevel(status), "error response", "status", status, "body", body)
 	if media == "application/json" {
 		b, err := json.Marshal(body)
 		if err != nil {
@@ -76,18 +80,32 @@ func httpError(w http.ResponseWriter, media, body string, status int) {
 }
 
 func HandleError(w http.ResponseWriter, r Request, err error) {
-	handleError(w, r.Media(), err)
+	handleError(r.Context(), w, r.Media(), err)
 }
 
-func handleError(w http.ResponseWriter, media string, err error) {
+func handleError(ctx context.Context, w http.ResponseWriter, media string, err error) {
+	body, status := errorStatus(err)
+	httpError(ctx, w, media, body, status)
+}
+
+// errorStatus returns the body and status to respond with for err.
+func errorStatus(err error) (body string, status int) {
 	if herr, ok := err.(HTTPErr); ok {
-		httpError(w, media, herr.Body, herr.Status)
-		return
+		return herr.Body, herr.Status
+	}
+
+	switch err.(type) {
+	case ValidationErr, RejectedFieldsErr:
+		return err.Error(), 422
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return fmt.Sprintf("request body larger than %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge
 	}
 
 	if err == datastore.ErrNoSuchEntity {
-		httpError(w, media, err.Error(), 404)
-		return
+		return err.Error(), 404
 	}
 
 	if merr, ok := err.(appengine.MultiError); ok {
@@ -99,12 +117,11 @@ func handleError(w http.ResponseWriter, media string, err error) {
 			}
 		}
 		if only404 {
-			httpError(w, media, err.Error(), 404)
-			return
+			return err.Error(), 404
 		}
 	}
 
-	httpError(w, media, err.Error(), 500)
+	return err.Error(), 500
 }
 
 type Method int
@@ -146,25 +163,71 @@ func (m Method) HTTPMethod() string {
 
 type Request interface {
 	Req() *http.Request
+	// Context returns the context of the request, which is done when the client goes away.
+	Context() context.Context
+	// WithContext returns a shallow copy of the request using ctx as context.
+	// The copy shares Values with the original.
+	WithContext(ctx context.Context) Request
+	// SetContext replaces the context of the request for the rest of its handling, so that
+	// filters can give later filters, the handler, the post procs and the rendering a context
+	// with deadlines or values. ctx should be derived from Context().
+	SetContext(ctx context.Context)
 	Vars() map[string]string
 	NewLink(Link) Link
 	Values() map[string]interface{}
 	DecorateLinks(LinkDecorator)
 	Media() string
+	// Nonce returns the Content-Security-Policy nonce of the response, which
+	// scripts added to HTML responses must carry.
+	Nonce() string
 }
 
 type request struct {
 	req            *http.Request
+	ctx            context.Context
 	vars           map[string]string
 	values         map[string]interface{}
 	linkDecorators []LinkDecorator
 	media          string
+	formSubmission bool
+	formValues     url.Values
+	nonce          string
+	strict         bool
+	// openSpans end the spans started using startSpan, in the order they were started.
+	openSpans []func(error)
 }
 
 func (r *request) Media() string {
 	return r.media
 }
 
+func (r *request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return r.req.Context()
+}
+
+func (r *request) WithContext(ctx context.Context) Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	cpy := *r
+	cpy.ctx = ctx
+	return &cpy
+}
+
+func (r *request) SetContext(ctx context.Context) {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r.ctx = ctx
+}
+
+func (r *request) Nonce() string {
+	return r.nonce
+}
+
 func (r *request) Values() map[string]interface{} {
 	return r.values
 }
@@ -178,6 +241,8 @@ func (r *request) NewLink(l Link) Link {
 	rval.baseScheme = DefaultScheme
 	rval.baseHost = r.Req().Host
 	rval.linkDecorators = r.linkDecorators
+	rval.ctx = r.Context()
+	rval.req = r
 	return rval
 }
 
@@ -197,6 +262,7 @@ type ResponseWriter interface {
 type responseWriter struct {
 	http.ResponseWriter
 	content Content
+	status  int
 }
 
 func (r *responseWriter) SetContent(c Content) {
@@ -213,50 +279,48 @@ type Itemer interface {
 
 type Properties interface{}
 
+// Copy decodes the body of r into dest, skipping fields not writable using method.
+// The body must be JSON, or a native HTML form if enabled using SetFormSubmissions.
+// The body is limited to the max body size of the route, see SetMaxBodySize and Resource.MaxBodySize.
 func Copy(dest interface{}, r Request, method string) error {
-	b, err := ioutil.ReadAll(r.Req().Body)
-	if err != nil {
-		return err
-	}
-	return CopyBytes(dest, r, b, method)
+	return copyReader(dest, r, r.Req().Body, method, false)
 }
 
 func CopyBytes(dest interface{}, r Request, b []byte, method string) error {
+	return copyReader(dest, r, bytes.NewReader(b), method, false)
+}
+
+// copyReader decodes body into dest like Copy does. If fresh, dest is a new value, see decodeJSON.
+func copyReader(dest interface{}, r Request, body io.Reader, method string, fresh bool) error {
 	media, charset := Media(r.Req(), "Content-Type")
 	if strings.ToLower(charset) != "utf-8" && charset != "" {
 		return fmt.Errorf("unsupported character set %v", charset)
 	}
+	strict := false
+	if req, ok := r.(*request); ok {
+		strict = req.strict
+	}
 	switch media {
 	case "application/json":
-		return copyJSON(dest, b, method)
-	}
-	return fmt.Errorf("unsupported Content-Type %v", media)
-}
-
-func filterJSON(typ reflect.Type, m map[string]interface{}, method string) error {
-	docType, err := NewDocType(typ, method)
-	if err != nil {
-		return err
-	}
-	for key, value := range m {
-		field, found := docType.GetField(key)
-		if found {
-			if len(field.Type.Fields) > 0 {
-				if err := filterJSON(field.Type.typ, value.(map[string]interface{}), method); err != nil {
-					return err
-				}
-			} else if field.Type.Elem != nil && len(field.Type.Elem.Fields) > 0 {
-				for _, elem := range value.([]interface{}) {
-					if err := filterJSON(field.Type.Elem.typ, elem.(map[string]interface{}), method); err != nil {
-						return err
-					}
-				}
-			}
-		} else {
-			delete(m, key)
+		return decodeJSON(dest, body, method, strict, fresh)
+	case "application/x-www-form-urlencoded":
+		if !formSubmissions {
+			break
+		}
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		values, err := url.ParseQuery(string(b))
+		if err != nil {
+			return HTTPErr{Body: err.Error(), Status: 400}
 		}
+		if req, ok := r.(*request); ok {
+			req.formValues = values
+		}
+		return copyForm(dest, values, method, strict)
 	}
-	return nil
+	return fmt.Errorf("unsupported Content-Type %v", media)
 }
 
 type List []Content
@@ -283,6 +347,13 @@ func HeadCallback(f func(*Node) error) {
 	headCallbacks = append(headCallbacks, f)
 }
 
+// SetMaxBodySize limits the size of request bodies of all routes, unless overridden
+// by Resource.MaxBodySize. Larger bodies are rejected with a 413.
+// Zero, the default, means no limit.
+func SetMaxBodySize(n int64) {
+	maxBodySize = n
+}
+
 func SetJSONFormURL(u *url.URL) {
 	jsonFormURL = u
 }
@@ -312,11 +383,23 @@ func Media(r *http.Request, header string) (media, charset string) {
 func Handle(ro *mux.Router, pattern string, methods []string, routeName string, f func(ResponseWriter, Request) error) {
 	if router == nil {
 		router = ro
+		registerAssets(ro)
 	} else if router != ro {
 		panic("only one *mux.Router allowed")
 	}
-	ro.Path(pattern).Methods(methods...).HandlerFunc(func(httpW http.ResponseWriter, httpR *http.Request) {
-		log.Printf("%v\t%v\t%v ->", httpR.Method, httpR.URL.String(), routeName)
+	handler := func(origW http.ResponseWriter, httpR *http.Request) {
+		httpW := &statusWriter{ResponseWriter: origW}
+		r := &request{
+			req:    httpR,
+			vars:   mux.Vars(httpR),
+			values: map[string]interface{}{},
+		}
+		defer startMetrics(httpW, r, routeName)()
+		httpR, endTrace := startTrace(httpW, httpR, routeName)
+		r.req, r.ctx = httpR, httpR.Context()
+		defer endTrace()
+		defer logAccess(httpW, r, routeName, time.Now())
+		defer recoverPanic(httpW, r, routeName)
 		CORSHeaders(httpW)
 		media, charset := Media(httpR, "Accept")
 
@@ -332,28 +415,56 @@ func Handle(ro *mux.Router, pattern string, methods []string, routeName string,
 			return
 		}
 
-		w := &responseWriter{
-			ResponseWriter: httpW,
+		opts, found := routeOpts[routeName]
+		if !found {
+			opts = &routeOptions{}
 		}
-		r := &request{
-			req:    httpR,
-			vars:   mux.Vars(httpR),
-			values: map[string]interface{}{},
-			media:  media,
+		limit := maxBodySize
+		if opts.maxBodySize != 0 {
+			limit = opts.maxBodySize
 		}
-
-		for _, filter := range filters {
-			cont, err := filter(w, r)
-			if err != nil {
-				HandleError(httpW, r, err)
-				return
-			}
-			if !cont {
+		if limit > 0 {
+			if httpR.ContentLength > limit {
+				handleError(httpR.Context(), httpW, media, &http.MaxBytesError{Limit: limit})
 				return
 			}
+			httpR.Body = http.MaxBytesReader(httpW, httpR.Body, limit)
+		}
+
+		nonce, err := newNonce()
+		if err != nil {
+			handleError(httpR.Context(), httpW, media, err)
+			return
 		}
 
-		err := f(w, r)
+		w := &responseWriter{
+			ResponseWriter: httpW,
+		}
+		r.media = media
+		r.formSubmission = isFormSubmission(httpR)
+		r.nonce = nonce
+		r.strict = opts.strict
+
+		endFilters := r.startSpan("filters")
+		proceed, err := runFilters(w, r, routeName, opts)
+		endFilters(err)
+		if err != nil {
+			HandleError(httpW, r, err)
+			return
+		}
+		if !proceed {
+			return
+		}
+
+		if err := r.ctx.Err(); err != nil {
+			logMsg(httpR.Context(), LogInfo, "aborted", "method", httpR.Method, "url", httpR.URL.String(), "route", routeName, "error", err)
+			return
+		}
+		endHandler := r.startSpan("handler")
+		if err = authorize(w, r, opts); err == nil {
+			err = f(w, r)
+		}
+		endHandler(err)
 		cont := false
 		for _, postProc := range postProcs {
 			cont, err = postProc(w, r, err)
@@ -361,85 +472,111 @@ func Handle(ro *mux.Router, pattern string, methods []string, routeName string,
 				break
 			}
 		}
+		if r.formSubmission && media == "text/html" {
+			if err == nil {
+				err = redirectAfterForm(w, r)
+			} else if content, status, ok := formErrorContent(r, routeName, err); ok {
+				w.content = content
+				w.status = status
+				err = nil
+			}
+		}
 		if err != nil {
 			HandleError(httpW, r, err)
 		}
 
 		if w.content != nil {
+			if err := r.ctx.Err(); err != nil {
+				logMsg(httpR.Context(), LogInfo, "aborted before rendering", "method", httpR.Method, "url", httpR.URL.String(), "route", routeName, "error", err)
+				return
+			}
 			renderF := map[string]func(http.ResponseWriter) error{
 				"text/html": func(httpW http.ResponseWriter) error {
 					contentNode, err := w.content.HTMLNode()
 					if err != nil {
 						return err
 					}
-					htmlNode := NewEl("html")
-					headNode := htmlNode.AddEl("head")
+					headNode := NewEl("head")
 					for _, cb := range headCallbacks {
 						if err := cb(headNode); err != nil {
 							return err
 						}
 					}
-					headNode.AddEl("script", "src", "https://ajax.googleapis.com/ajax/libs/jquery/3.1.1/jquery.min.js")
-					headNode.AddEl("script", "src", "https://cdnjs.cloudflare.com/ajax/libs/underscore.js/1.6.0/underscore-min.js")
-					if jsonFormURL != nil {
-						headNode.AddEl("script", "src", jsonFormURL.String())
-					} else {
-						headNode.AddEl("script").AddText(jsonformJS())
+					if err := theme.Head(r, headNode); err != nil {
+						return err
 					}
-					if jsvURL != nil {
-						headNode.AddEl("script", "src", jsvURL.String())
-					} else {
-						headNode.AddEl("script").AddText(jsvJS())
+					htmlNode, err := theme.Layout(r, headNode, contentNode)
+					if err != nil {
+						return err
 					}
-					headNode.AddEl("style").AddText(`
-nav > form {
-	padding: 5pt;
-	margin: 0pt;
-	border-style: inset;
-}
-section {
-	border-style: outset;
-	padding: 5pt;
-	margin: 5pt;
-}
-section > header {
-	font-weight: bold;
-}
-section > article {
-	border-style: inset;
-	padding: 5pt;
-	margin: 5pt;
-}
-section > article > header {
-	font-weight: bold;
-}
-nav {
-	padding: 5pt;
-	margin: 5pt;
-}
-nav > a {
-	margin: 5pt;
-}
-fieldset.control-group {
-	border: 4px outset;
-	padding: 5pt;
-	margin: 5pt;
-}
-`)
-					htmlNode.AddEl("body").AddNode(contentNode)
 					httpW.Header().Set("Content-Type", "text/html; charset=UTF-8")
-					return htmlNode.Render(httpW)
+					if contentSecurityPolicy != nil {
+						httpW.Header().Set("Content-Security-Policy", contentSecurityPolicy(r.nonce))
+					}
+					if w.status != 0 {
+						httpW.WriteHeader(w.status)
+					}
+					return htmlNode.Render(contextWriter{ctx: r.ctx, w: httpW})
 				},
 				"application/json": func(httpW http.ResponseWriter) error {
 					httpW.Header().Set("Content-Type", "application/json; charset=UTF-8")
-					return json.NewEncoder(httpW).Encode(w.content)
+					return json.NewEncoder(contextWriter{ctx: r.ctx, w: httpW}).Encode(w.content)
 				},
 			}[media]
-			if err := renderF(httpW); err != nil {
+			endRender := r.startSpan("render", "media", media)
+			err := renderF(httpW)
+			endRender(err)
+			if err != nil {
+				if r.ctx.Err() != nil {
+					logMsg(httpR.Context(), LogInfo, "aborted while rendering", "method", httpR.Method, "url", httpR.URL.String(), "route", routeName, "error", err)
+					return
+				}
 				HandleError(httpW, r, err)
 			}
 		}
-	}).Name(routeName)
+	}
+	route := ro.Path(pattern).Methods(methods...).HandlerFunc(handler).Name(routeName)
+	registerMethodOverrides(ro, route, pattern, methods, handler)
+}
+
+// runFilters runs the authentication, rate limiting, CSRF protection and filters of a request
+// to routeName, and returns whether its handler should run.
+func runFilters(w ResponseWriter, r *request, routeName string, opts *routeOptions) (bool, error) {
+	limit, bucketsName := routeRateLimit(routeName, opts.rateLimit)
+	// Limits by IP apply before authenticating, so that guessing credentials is limited too.
+	limitedByIP := limit != nil && limit.Key == nil
+	if limitedByIP {
+		if err := limitRate(w, r, limit, bucketsName, nil); err != nil {
+			return false, err
+		}
+	}
+	if err := authenticate(w, r, opts.auth); err != nil {
+		if !limitedByIP {
+			if limitErr := limitRate(w, r, limit, bucketsName, RemoteIPKey); limitErr != nil {
+				return false, limitErr
+			}
+		}
+		return false, err
+	}
+	if !limitedByIP {
+		if err := limitRate(w, r, limit, bucketsName, nil); err != nil {
+			return false, err
+		}
+	}
+	if err := checkCSRF(w, r); err != nil {
+		return false, err
+	}
+	for _, filter := range filters {
+		if err := r.ctx.Err(); err != nil {
+			logMsg(r.req.Context(), LogInfo, "aborted", "method", r.req.Method, "url", r.req.URL.String(), "route", routeName, "error", err)
+			return false, nil
+		}
+		cont, err := filter(w, r)
+		if err != nil || !cont {
+			return false, err
+		}
+	}
+	return true, nil
 }
 
 func CORSHeaders(w http.ResponseWriter) {
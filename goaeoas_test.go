@@ -1,6 +1,12 @@
 package goaeoas
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
@@ -170,3 +176,933 @@ func TestCopyJSON(t *testing.T) {
 		t.Errorf("Wrong copy result, got %v, want %v; diff %v", spew.Sdump(outer), spew.Sdump(expectedPUTOuter), spew.Sdump(diff))
 	}
 }
+
+type Shape struct {
+	POSTInteger int `methods:"POST"`
+	PUTInteger  int `methods:"PUT"`
+}
+
+type EmbeddedShape struct {
+	POSTEmbedded int `methods:"POST"`
+	PUTEmbedded  int `methods:"PUT"`
+}
+
+type Shapes struct {
+	*EmbeddedShape `methods:"POST,PUT"`
+	Pointer        *Shape            `methods:"POST,PUT"`
+	Map            map[string]Shape  `methods:"POST,PUT"`
+	PointerMap     map[string]*Shape `methods:"POST,PUT"`
+	IntMap         map[int]Shape     `methods:"POST,PUT"`
+	Nested         [][]Shape         `methods:"POST,PUT"`
+	Array          [2]Shape          `methods:"POST,PUT"`
+}
+
+func TestCopyJSONShapes(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		method     string
+		json       string
+		want       *Shapes
+		wantStatus int
+	}{
+		{
+			name:   "pointer",
+			method: "POST",
+			json:   `{"Pointer":{"POSTInteger":1,"PUTInteger":2}}`,
+			want:   &Shapes{Pointer: &Shape{POSTInteger: 1}},
+		},
+		{
+			name:   "null pointer",
+			method: "POST",
+			json:   `{"Pointer":null}`,
+			want:   &Shapes{},
+		},
+		{
+			name:   "map",
+			method: "PUT",
+			json:   `{"Map":{"a":{"POSTInteger":1,"PUTInteger":2}}}`,
+			want:   &Shapes{Map: map[string]Shape{"a": {PUTInteger: 2}}},
+		},
+		{
+			name:   "pointer map",
+			method: "POST",
+			json:   `{"PointerMap":{"a":null,"b":{"POSTInteger":1,"PUTInteger":2}}}`,
+			want:   &Shapes{PointerMap: map[string]*Shape{"a": nil, "b": {POSTInteger: 1}}},
+		},
+		{
+			name:   "int map",
+			method: "PUT",
+			json:   `{"IntMap":{"7":{"POSTInteger":1,"PUTInteger":2}}}`,
+			want:   &Shapes{IntMap: map[int]Shape{7: {PUTInteger: 2}}},
+		},
+		{
+			name:   "nested slices",
+			method: "POST",
+			json:   `{"Nested":[[{"POSTInteger":1,"PUTInteger":2}],null]}`,
+			want:   &Shapes{Nested: [][]Shape{{{POSTInteger: 1}}, nil}},
+		},
+		{
+			name:   "array",
+			method: "PUT",
+			json:   `{"Array":[{"POSTInteger":1,"PUTInteger":2}]}`,
+			want:   &Shapes{Array: [2]Shape{{PUTInteger: 2}, {}}},
+		},
+		{
+			name:   "embedded pointer",
+			method: "POST",
+			json:   `{"POSTEmbedded":1,"PUTEmbedded":2}`,
+			want:   &Shapes{EmbeddedShape: &EmbeddedShape{POSTEmbedded: 1}},
+		},
+		{
+			name:       "number for pointer",
+			method:     "POST",
+			json:       `{"Pointer":5}`,
+			wantStatus: 400,
+		},
+		{
+			name:       "array for map",
+			method:     "POST",
+			json:       `{"Map":[]}`,
+			wantStatus: 400,
+		},
+		{
+			name:       "object for nested slice",
+			method:     "POST",
+			json:       `{"Nested":[{}]}`,
+			wantStatus: 400,
+		},
+		{
+			name:       "invalid map key",
+			method:     "POST",
+			json:       `{"IntMap":{"x":{}}}`,
+			wantStatus: 400,
+		},
+		{
+			name:       "array for body",
+			method:     "POST",
+			json:       `[]`,
+			wantStatus: 400,
+		},
+		{
+			name:       "truncated",
+			method:     "POST",
+			json:       `{"Pointer":{"POSTInteger":`,
+			wantStatus: 400,
+		},
+	} {
+		got := &Shapes{}
+		err := copyJSON(got, []byte(tc.json), tc.method)
+		if tc.wantStatus != 0 {
+			if httpErr, ok := err.(HTTPErr); !ok || httpErr.Status != tc.wantStatus {
+				t.Errorf("%s: got %v, want HTTPErr with status %v", tc.name, err, tc.wantStatus)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if diff := pretty.Diff(got, tc.want); len(diff) > 0 {
+			t.Errorf("%s: got %v, want %v; diff %v", tc.name, spew.Sdump(got), spew.Sdump(tc.want), spew.Sdump(diff))
+		}
+	}
+}
+
+type Account struct {
+	ID       string `access:"generated"`
+	Email    string `access:"writeonce"`
+	Balance  int    `access:"readonly"`
+	Password string `methods:"POST,PUT" access:"writeonly" json:",omitempty"`
+	Name     string `methods:"POST,PUT"`
+}
+
+type Login interface {
+	isLogin()
+}
+
+type PasswordLogin struct {
+	Type     string
+	Email    string `access:"writeonce"`
+	Password string `methods:"POST,PUT"`
+}
+
+func (p *PasswordLogin) isLogin() {}
+
+type Logins struct {
+	Email string `access:"writeonce"`
+	Main  Login  `methods:"POST,PUT"`
+}
+
+func TestAccess(t *testing.T) {
+	body := `{"ID":"x","Email":"a@b","Balance":10,"Password":"secret","Name":"n"}`
+	account := &Account{}
+	if err := copyJSON(account, []byte(body), "POST"); err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Account{Email: "a@b", Password: "secret", Name: "n"}); !reflect.DeepEqual(account, want) {
+		t.Errorf("got %+v, want %+v", account, want)
+	}
+	account = &Account{ID: "1", Email: "a@b"}
+	if err := decodeJSON(account, strings.NewReader(`{"ID":"2","Email":"a@b","Name":"m"}`), "PUT", true, false); err != nil {
+		t.Errorf("got %v for unchanged write once field in strict mode", err)
+	}
+	if account.ID != "1" || account.Email != "a@b" || account.Name != "m" {
+		t.Errorf("got %+v", account)
+	}
+	RegisterUnion(Union{
+		Interface:     reflect.TypeOf((*Login)(nil)).Elem(),
+		Discriminator: "Type",
+		Variants: map[string]reflect.Type{
+			"Password": reflect.TypeOf(&PasswordLogin{}),
+		},
+	})
+	logins := &Logins{}
+	if err := decodeJSON(logins, strings.NewReader(`{"Email":"a@b","Main":{"Type":"Password","Email":"a@b","Password":"p"}}`), "PUT", true, true); err != nil {
+		t.Errorf("got %v for write once fields of a fresh union variant", err)
+	}
+	if want := (&Logins{Main: &PasswordLogin{Type: "Password", Password: "p"}}); !reflect.DeepEqual(logins, want) {
+		t.Errorf("got %s, want %s", spew.Sdump(logins), spew.Sdump(want))
+	}
+	err := copyJSON(account, []byte(`{"Email":"c@d"}`), "PUT")
+	if verr, ok := err.(ValidationErr); !ok || verr.Fields["Email"] == "" {
+		t.Errorf("got %v, want ValidationErr for Email", err)
+	}
+	err = decodeJSON(account, strings.NewReader(`{"Balance":5}`), "PUT", true, false)
+	if rerr, ok := err.(RejectedFieldsErr); !ok || len(rerr.Fields) != 1 || rerr.Fields[0].Reason != NotWritableField {
+		t.Errorf("got %v, want Balance rejected as not writable", err)
+	}
+
+	getType, err := NewDocType(reflect.TypeOf(Account{}), "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	getSchema, err := getType.ToJSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := getSchema.Properties["Password"]; found {
+		t.Errorf("write only field in GET schema %+v", getSchema)
+	}
+	if !getSchema.Properties["ID"].ReadOnly || !getSchema.Properties["Balance"].ReadOnly || getSchema.Properties["Email"].ReadOnly {
+		t.Errorf("wrong readOnly in GET schema %+v", getSchema)
+	}
+	putType, err := NewDocType(reflect.TypeOf(Account{}), "PUT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	putSchema, err := putType.ToJSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !putSchema.Properties["Password"].WriteOnly {
+		t.Errorf("Password not writeOnly in PUT schema %+v", putSchema)
+	}
+	if _, found := putSchema.Properties["Email"]; found {
+		t.Errorf("write once field in PUT schema %+v", putSchema)
+	}
+
+	b, err := json.Marshal(NewItem(&Account{Name: "n", Password: "secret"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "secret") || !strings.Contains(string(b), `"Name":"n"`) {
+		t.Errorf("got %s, want Name without Password", b)
+	}
+	type nested struct {
+		*Account
+		Main    Account
+		Friends []Account
+		Best    *Account
+		ByName  map[string]Account
+		Pair    [1]Account
+		Any     interface{}
+	}
+	original := &nested{
+		Account: &Account{Name: "embedded", Password: "p0"},
+		Main:    Account{Name: "main", Password: "p1"},
+		Friends: []Account{{Name: "friend", Password: "p2"}},
+		Best:    &Account{Name: "best", Password: "p3"},
+		ByName:  map[string]Account{"a": {Name: "mapped", Password: "p4"}},
+		Pair:    [1]Account{{Name: "paired", Password: "p5"}},
+		Any:     []interface{}{&Account{Name: "any", Password: "p6"}},
+	}
+	if b, err = json.Marshal(NewItem(original)); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"embedded", "main", "friend", "best", "mapped", "paired", "any"} {
+		if !strings.Contains(string(b), `"Name":"`+name+`"`) {
+			t.Errorf("got %s, want Name %s", b, name)
+		}
+	}
+	if strings.Contains(string(b), `"Password"`) {
+		t.Errorf("got %s, want no nested Password", b)
+	}
+	if original.Account.Password != "p0" || original.Friends[0].Password != "p2" || original.Best.Password != "p3" || original.ByName["a"].Password != "p4" || original.Any.([]interface{})[0].(*Account).Password != "p6" {
+		t.Errorf("hiding write only fields changed the original %+v", original)
+	}
+
+	allType, err := NewDocType(reflect.TypeOf(Account{}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	classes, err := allType.ToJavaClasses("account", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"/** Generated by the server. */\n  public String ID;",
+		"/** Write only, never returned by the server. */\n  public String Password;",
+	} {
+		if !strings.Contains(classes["Account"], want) {
+			t.Errorf("got %s, wanted it to contain %s", classes["Account"], want)
+		}
+	}
+
+	if _, err := NewDocType(reflect.TypeOf(struct {
+		Field string `access:"sometimes"`
+	}{}), "GET"); err == nil {
+		t.Errorf("got no error for unknown access")
+	}
+}
+
+type Tagged struct {
+	UserName string `json:"user_name" methods:"POST"`
+	Count    int64  `json:"count,string" methods:"POST"`
+	Note     string `json:",omitempty" methods:"POST"`
+	hidden   string
+}
+
+func TestJSONTags(t *testing.T) {
+	tagged := &Tagged{}
+	if err := copyJSON(tagged, []byte(`{"user_name":"a","COUNT":"12","Note":"n","UserName":"x"}`), "POST"); err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Tagged{UserName: "a", Count: 12, Note: "n"}); !reflect.DeepEqual(tagged, want) {
+		t.Errorf("got %+v, want %+v", tagged, want)
+	}
+	if err := copyJSON(tagged, []byte(`{"count":12}`), "POST"); err == nil {
+		t.Errorf("got no error for unquoted string option field")
+	}
+	tagged = &Tagged{}
+	if err := copyForm(tagged, url.Values{"user_name": {"b"}, "count": {"3"}}, "POST", true); err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Tagged{UserName: "b", Count: 3}); !reflect.DeepEqual(tagged, want) {
+		t.Errorf("got %+v, want %+v", tagged, want)
+	}
+
+	docType, err := NewDocType(reflect.TypeOf(Tagged{}), "POST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docType.Fields) != 3 {
+		t.Errorf("got fields %+v, want user_name, count and Note", docType.Fields)
+	}
+	if field, found := docType.GetField("Note"); !found || !field.OmitEmpty {
+		t.Errorf("got %+v, want Note with OmitEmpty", field)
+	}
+	schema, err := docType.ToJSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.Properties["user_name"].Type != "string" || schema.Properties["count"].Type != "string" {
+		t.Errorf("got %+v, want user_name and count as strings", schema)
+	}
+	if len(schema.Required) != 0 {
+		t.Errorf("got required %v in POST schema, want none", schema.Required)
+	}
+	getType, err := NewDocType(reflect.TypeOf(Tagged{}), "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	getSchema, err := getType.ToJSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"user_name", "count"}; !reflect.DeepEqual(getSchema.Required, want) {
+		t.Errorf("got required %v in GET schema, want %v", getSchema.Required, want)
+	}
+	classes, err := docType.ToJavaClasses("tagged", "POST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"@com.google.gson.annotations.SerializedName(\"user_name\")\n  public String UserName;",
+		"@com.google.gson.annotations.SerializedName(\"count\")\n  public String Count;",
+		"/** Left out by the server when empty. */\n  public String Note;",
+	} {
+		if !strings.Contains(classes["Tagged"], want) {
+			t.Errorf("got %s, wanted it to contain %s", classes["Tagged"], want)
+		}
+	}
+	node, err := PropertiesNode(&Tagged{UserName: "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := node.Render(buf); err != nil {
+		t.Fatal(err)
+	}
+	if html := buf.String(); !strings.Contains(html, "<th>user_name</th>") || strings.Contains(html, "hidden") {
+		t.Errorf("got %s, want user_name row and no hidden row", html)
+	}
+}
+
+type textID int
+
+func (t textID) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprint(int(t))), nil
+}
+
+type rawThing struct{}
+
+func (r rawThing) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
+
+type Money struct {
+	Cents int
+}
+
+func (m *Money) JSONSchema() *JSONSchema {
+	return &JSONSchema{Type: "string", Title: "money"}
+}
+
+func (m *Money) JavaType() string {
+	return "java.math.BigDecimal"
+}
+
+type Celsius float64
+
+func TestTypeMatrix(t *testing.T) {
+	RegisterType(reflect.TypeOf(Celsius(0)), TypeMapping{
+		JSONSchema: func() *JSONSchema {
+			return &JSONSchema{Type: "number", Title: "celsius"}
+		},
+		Java: "Double",
+	})
+	for _, tc := range []struct {
+		typ        reflect.Type
+		wantSchema string
+		wantJava   string
+	}{
+		{reflect.TypeOf(int8(0)), `{"type":"integer"}`, "Long"},
+		{reflect.TypeOf(int16(0)), `{"type":"integer"}`, "Long"},
+		{reflect.TypeOf(uint8(0)), `{"type":"integer","minimum":0}`, "Long"},
+		{reflect.TypeOf(uint16(0)), `{"type":"integer","minimum":0}`, "Long"},
+		{reflect.TypeOf(uint32(0)), `{"type":"integer","minimum":0}`, "Long"},
+		{reflect.TypeOf(uint64(0)), `{"type":"integer","minimum":0}`, "java.math.BigInteger"},
+		{reflect.TypeOf(uint(0)), `{"type":"integer","minimum":0}`, "java.math.BigInteger"},
+		{reflect.TypeOf(float32(0)), `{"type":"number"}`, "Float"},
+		{reflect.TypeOf([]byte{}), `{"type":"string","contentEncoding":"base64"}`, "String"},
+		{reflect.TypeOf((*int)(nil)), `{"type":["integer","null"]}`, "Long"},
+		{reflect.TypeOf((*string)(nil)), `{"type":["string","null"]}`, "String"},
+		{reflect.TypeOf((*Shape)(nil)), `{"type":["object","null"],"properties":{"POSTInteger":{"type":"integer","title":"POSTInteger"},"PUTInteger":{"type":"integer","title":"PUTInteger"}}}`, "Shape"},
+		{reflect.TypeOf((*interface{})(nil)).Elem(), `{}`, "Object"},
+		{reflect.TypeOf([3]int{}), `{"type":"array","items":{"type":"integer"},"minItems":3,"maxItems":3}`, "java.util.List<Long>"},
+		{reflect.TypeOf(textID(0)), `{"type":"string"}`, "String"},
+		{reflect.TypeOf(rawThing{}), `{}`, "com.google.gson.JsonElement"},
+		{reflect.TypeOf(Money{}), `{"type":"string","title":"money"}`, "java.math.BigDecimal"},
+		{reflect.TypeOf(Celsius(0)), `{"type":"number","title":"celsius"}`, "Double"},
+	} {
+		docType, err := NewDocType(tc.typ, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema, err := docType.ToJSONSchema()
+		if err != nil {
+			t.Errorf("%v: %v", tc.typ, err)
+			continue
+		}
+		b, err := json.Marshal(schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tc.wantSchema {
+			t.Errorf("%v: got schema %s, want %s", tc.typ, b, tc.wantSchema)
+		}
+		javaType, err := docType.javaTypeFor(map[string]string{}, tc.typ, "matrix", "", "")
+		if err != nil {
+			t.Errorf("%v: %v", tc.typ, err)
+			continue
+		}
+		if javaType != tc.wantJava {
+			t.Errorf("%v: got Java type %v, want %v", tc.typ, javaType, tc.wantJava)
+		}
+	}
+	chanType, err := NewDocType(reflect.TypeOf(make(chan int)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chanType.ToJSONSchema(); err == nil {
+		t.Errorf("got no error for channel schema")
+	}
+}
+
+type Author struct {
+	Name string `methods:"POST"`
+}
+
+type Comment struct {
+	Text    string    `methods:"POST"`
+	Author  *Author   `methods:"POST"`
+	Replies []Comment `methods:"POST"`
+}
+
+type TreeNode struct {
+	Value int       `methods:"POST"`
+	Left  *TreeNode `methods:"POST"`
+	Right *TreeNode `methods:"POST"`
+}
+
+func TestRecursiveTypes(t *testing.T) {
+	commentType, err := NewDocType(reflect.TypeOf(Comment{}), "POST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := NewDocType(reflect.TypeOf(Comment{}), "POST"); err != nil || again != commentType {
+		t.Errorf("got %p, %v, want cached %p", again, err, commentType)
+	}
+	if replies, found := commentType.GetField("Replies"); !found || replies.Type.Elem != commentType {
+		t.Errorf("Replies doesn't refer back to Comment")
+	}
+	schema, err := commentType.ToJSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref := schema.Properties["Replies"].Items.Ref; ref != "#" {
+		t.Errorf("got Replies items %q, want #", ref)
+	}
+	if author := schema.Properties["Author"]; len(author.AnyOf) != 2 || author.AnyOf[0].Ref != "#/definitions/Author" || author.AnyOf[1].Type != "null" {
+		t.Errorf("got Author %+v, want #/definitions/Author or null", author)
+	}
+	if b, err := json.Marshal(schema.Properties["Author"]); err != nil || !strings.Contains(string(b), `"anyOf":[{"$ref":"#/definitions/Author"},{"type":"null"}]`) {
+		t.Errorf("got Author %s, %v, want anyOf $ref and null", b, err)
+	}
+	if typ := schema.Definitions["Author"].Properties["Name"].Type; typ != "string" {
+		t.Errorf("got Author.Name type %q, want string", typ)
+	}
+	// Changing a returned schema doesn't change the cached one.
+	if schema, err = commentType.ToJSONSchema(); err != nil {
+		t.Fatal(err)
+	}
+	delete(schema.Properties, "Replies")
+	schema.Definitions["Author"].Properties["Name"] = JSONSchema{Type: "integer"}
+	schema.Properties["Author"].AnyOf[1].Type = "string"
+	again, err := commentType.ToJSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := again.Properties["Replies"]; !found || again.Definitions["Author"].Properties["Name"].Type != "string" || again.Properties["Author"].AnyOf[1].Type != "null" {
+		t.Errorf("got %+v, want the cached schema unchanged", again)
+	}
+
+	treeType, err := NewDocType(reflect.TypeOf(TreeNode{}), "POST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inline, err := treeType.ToInlineJSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inline.Properties["Value"].Type != "integer" {
+		t.Errorf("got %+v, want Value integer", inline)
+	}
+	if left := inline.Properties["Left"]; left.Type != "object" || !left.Nullable || len(left.Properties) != 0 || left.Ref != "" {
+		t.Errorf("got Left %+v, want nullable object without properties", left)
+	}
+	if _, err := json.Marshal(inline); err != nil {
+		t.Fatal(err)
+	}
+
+	classes, err := treeType.ToJavaClasses("tree", "POST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(classes["TreeNode"], "public TreeNode Left;") {
+		t.Errorf("got %s, want TreeNode with Left", classes["TreeNode"])
+	}
+
+	tree := &TreeNode{}
+	if err := copyJSON(tree, []byte(`{"Value":1,"Left":{"Value":2,"Right":{"Value":3}}}`), "POST"); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Left == nil || tree.Left.Right == nil || tree.Left.Right.Value != 3 {
+		t.Errorf("got %s", spew.Sdump(tree))
+	}
+}
+
+type Order interface {
+	isOrder()
+}
+
+type MoveOrder struct {
+	Type   string
+	To     string `methods:"POST,PUT"`
+	Secret string
+}
+
+func (m *MoveOrder) isOrder() {}
+
+type HoldOrder struct {
+	Type string
+	Unit string `methods:"POST"`
+}
+
+func (h HoldOrder) isOrder() {}
+
+type Orders struct {
+	Main   Order   `methods:"POST,PUT"`
+	Others []Order `methods:"POST"`
+}
+
+func TestUnion(t *testing.T) {
+	RegisterUnion(Union{
+		Interface:     reflect.TypeOf((*Order)(nil)).Elem(),
+		Discriminator: "Type",
+		Variants: map[string]reflect.Type{
+			"Move": reflect.TypeOf(&MoveOrder{}),
+			"Hold": reflect.TypeOf(HoldOrder{}),
+		},
+	})
+	orders := &Orders{}
+	if err := copyJSON(orders, []byte(`{"Main":{"To":"x","Type":"Move","Secret":"s"},"Others":[{"Type":"Hold","Unit":"u"},null]}`), "POST"); err != nil {
+		t.Fatal(err)
+	}
+	want := &Orders{
+		Main:   &MoveOrder{Type: "Move", To: "x"},
+		Others: []Order{HoldOrder{Type: "Hold", Unit: "u"}, nil},
+	}
+	if !reflect.DeepEqual(orders, want) {
+		t.Errorf("got %s, want %s", spew.Sdump(orders), spew.Sdump(want))
+	}
+	main := orders.Main
+	if err := copyJSON(orders, []byte(`{"Main":{"Type":"Move","To":"y"}}`), "PUT"); err != nil {
+		t.Fatal(err)
+	}
+	if orders.Main.(*MoveOrder).To != "y" || main.(*MoveOrder).To != "x" {
+		t.Errorf("got %+v, want a changed copy of %+v", orders.Main, main)
+	}
+	for _, body := range []string{
+		`{"Main":{"To":"x"}}`,
+		`{"Main":{"Type":"Jump"}}`,
+		`{"Main":[]}`,
+	} {
+		if err, ok := copyJSON(&Orders{}, []byte(body), "POST").(HTTPErr); !ok || err.Status != 400 {
+			t.Errorf("%s: got %v, want 400", body, err)
+		}
+	}
+	err := decodeJSON(&Orders{}, strings.NewReader(`{"Main":{"Type":"Move","Secret":"s"}}`), "POST", true, false)
+	if rerr, ok := err.(RejectedFieldsErr); !ok || len(rerr.Fields) != 1 || rerr.Fields[0].Path != "Main.Secret" {
+		t.Errorf("got %v, want Main.Secret rejected", err)
+	}
+
+	docType, err := NewDocType(reflect.TypeOf(Orders{}), "POST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := docType.ToJSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mainSchema := schema.Properties["Main"]
+	if len(mainSchema.OneOf) != 2 || mainSchema.Discriminator.PropertyName != "Type" || mainSchema.Discriminator.Mapping["Move"] != "#/definitions/MoveOrder" {
+		t.Errorf("got %+v, want oneOf with discriminator", mainSchema)
+	}
+	if _, found := schema.Definitions["HoldOrder"]; !found {
+		t.Errorf("no HoldOrder definition in %+v", schema)
+	}
+
+	allType, err := NewDocType(reflect.TypeOf(Orders{}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	classes, err := allType.ToJavaClasses("orders", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for class, want := range map[string]string{
+		"Order":             "public abstract class Order",
+		"MoveOrder":         "public class MoveOrder extends Order",
+		"HoldOrder":         "public class HoldOrder extends Order",
+		"OrderDeserializer": "case \"Hold\":\n      return context.deserialize(json, HoldOrder.class);",
+		"Orders":            "public java.util.List<Order> Others;",
+	} {
+		if !strings.Contains(classes[class], want) {
+			t.Errorf("got %s, wanted it to contain %s", classes[class], want)
+		}
+	}
+}
+
+type EmbedBase struct {
+	ID    string `methods:"POST"`
+	Name  string `methods:"POST"`
+	Label string `methods:"POST"`
+}
+
+type EmbedExtra struct {
+	Label string `json:"Label" methods:"POST"`
+	Note  string `methods:"POST"`
+}
+
+type Embedding struct {
+	*EmbedBase `methods:"POST"`
+	EmbedExtra `methods:"POST"`
+	Name       string `methods:"POST"`
+}
+
+type ConflictA struct {
+	Dup string
+}
+
+type ConflictB struct {
+	Dup string
+}
+
+type Conflicting struct {
+	ConflictA
+	ConflictB
+	ID string
+}
+
+type privateBase struct {
+	Shown    string
+	Password string `access:"writeonly"`
+}
+
+type PrivateEmbedding struct {
+	*privateBase
+	ID string
+}
+
+type ShadowedInner struct {
+	IsAdmin bool   `methods:"PUT"`
+	Secret  string `methods:"PUT"`
+}
+
+type Shadowing struct {
+	ShadowedInner `methods:"PUT"`
+	IsAdmin       bool
+	Secret        string `methods:"PUT" access:"writeonly"`
+}
+
+type EmbedLoop struct {
+	*EmbedLoop
+	Value string
+}
+
+func TestEmbedding(t *testing.T) {
+	docType, err := NewDocType(reflect.TypeOf(Embedding{}), "POST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, field := range docType.Fields {
+		names = append(names, field.Name)
+	}
+	if want := []string{"ID", "Label", "Note", "Name"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got fields %v, want %v", names, want)
+	}
+	body := []byte(`{"ID":"i","Name":"n","Label":"l","Note":"o"}`)
+	copied := &Embedding{}
+	if err := copyJSON(copied, body, "POST"); err != nil {
+		t.Fatal(err)
+	}
+	unmarshalled := &Embedding{}
+	if err := json.Unmarshal(body, unmarshalled); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(copied, unmarshalled) {
+		t.Errorf("got %s, want the same as encoding/json: %s", spew.Sdump(copied), spew.Sdump(unmarshalled))
+	}
+	schema, err := docType.ToJSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.Properties) != 4 {
+		t.Errorf("got %+v, want 4 properties", schema.Properties)
+	}
+	classes, err := docType.ToJavaClasses("embedding", "POST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"ID", "Label", "Note", "Name"} {
+		if count := strings.Count(classes["Embedding"], fmt.Sprintf("public String %s;", field)); count != 1 {
+			t.Errorf("got %v declarations of %v in %s", count, field, classes["Embedding"])
+		}
+	}
+
+	// Like encoding/json, conflicting fields are left out.
+	for _, properties := range []interface{}{
+		&Conflicting{ConflictA: ConflictA{Dup: "a"}, ConflictB: ConflictB{Dup: "b"}, ID: "x"},
+		&PrivateEmbedding{privateBase: &privateBase{Shown: "s"}, ID: "x"},
+		&PrivateEmbedding{ID: "x"},
+	} {
+		want, err := json.Marshal(properties)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(NewItem(properties))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), `"Properties":`+string(want)) {
+			t.Errorf("got %s, want Properties %s like encoding/json", b, want)
+		}
+		if _, err := PropertiesNode(properties); err != nil {
+			t.Errorf("got %v rendering %+v", err, properties)
+		}
+	}
+	conflictingType, err := NewDocType(reflect.TypeOf(Conflicting{}), "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflictingType.Fields) != 1 || conflictingType.Fields[0].Name != "ID" {
+		t.Errorf("got %+v, want only ID", conflictingType.Fields)
+	}
+	privateType, err := NewDocType(reflect.TypeOf(PrivateEmbedding{}), "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := privateType.GetField("Shown"); !found {
+		t.Errorf("got %+v, want Shown of the embedded pointer to an unexported struct", privateType.Fields)
+	}
+	// Write only fields that can't be hidden fail instead of leaking.
+	if b, err := json.Marshal(NewItem(&PrivateEmbedding{privateBase: &privateBase{Password: "p"}})); err == nil {
+		t.Errorf("got %s, want an error", b)
+	}
+	// Shallow fields shadow deeper ones even when they aren't part of the DocType for the method.
+	shadowing := &Shadowing{}
+	if err := copyJSON(shadowing, []byte(`{"IsAdmin":true,"Secret":"s"}`), "PUT"); err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Shadowing{Secret: "s"}); !reflect.DeepEqual(shadowing, want) {
+		t.Errorf("got %+v, want %+v", shadowing, want)
+	}
+	getType, err := NewDocType(reflect.TypeOf(Shadowing{}), "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names = []string{}
+	for _, field := range getType.Fields {
+		names = append(names, fmt.Sprintf("%s%v", field.Name, field.index))
+	}
+	if want := []string{"IsAdmin[1]"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got GET fields %v, want %v", names, want)
+	}
+
+	loopType, err := NewDocType(reflect.TypeOf(EmbedLoop{}), "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loopType.Fields) != 1 || loopType.Fields[0].Name != "Value" {
+		t.Errorf("got %+v, want only Value", loopType.Fields)
+	}
+
+	// Fields of nil embedded pointers are left out of responses.
+	shapesType, err := NewDocType(reflect.TypeOf(Shapes{}), "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shapesSchema, err := shapesType.ToJSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range shapesSchema.Required {
+		if name == "POSTEmbedded" || name == "PUTEmbedded" {
+			t.Errorf("got required %v, want no fields of the embedded pointer", shapesSchema.Required)
+		}
+	}
+	if len(shapesSchema.Required) == 0 || shapesSchema.Required[0] != "Pointer" {
+		t.Errorf("got required %v, want Pointer first", shapesSchema.Required)
+	}
+}
+
+// mapCopyJSON is the buffered implementation copyJSON replaced, kept to benchmark against.
+func mapCopyJSON(dest interface{}, b []byte, method string) error {
+	decoded := map[string]interface{}{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return err
+	}
+	if err := mapFilterJSON(reflect.TypeOf(dest).Elem(), decoded, method); err != nil {
+		return err
+	}
+	filtered, err := json.Marshal(decoded)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(filtered, dest)
+}
+
+func mapFilterJSON(typ reflect.Type, m map[string]interface{}, method string) error {
+	docType, err := NewDocType(typ, method)
+	if err != nil {
+		return err
+	}
+	for key, value := range m {
+		field, found := docType.GetField(key)
+		if found {
+			if len(field.Type.Fields) > 0 {
+				if err := mapFilterJSON(field.Type.typ, value.(map[string]interface{}), method); err != nil {
+					return err
+				}
+			} else if field.Type.Elem != nil && len(field.Type.Elem.Fields) > 0 {
+				for _, elem := range value.([]interface{}) {
+					if err := mapFilterJSON(field.Type.Elem.typ, elem.(map[string]interface{}), method); err != nil {
+						return err
+					}
+				}
+			}
+		} else {
+			delete(m, key)
+		}
+	}
+	return nil
+}
+
+func largeOuterJSON(b *testing.B, n int) []byte {
+	inner := Inner{
+		POSTInteger:  1,
+		POSTString:   "a",
+		POSTIntSlice: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		PUTInteger:   2,
+		PUTString:    "b",
+		PUTIntSlice:  []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+	}
+	outer := Outer{
+		POSTSubStruct: inner,
+		PUTSubStruct:  inner,
+	}
+	for i := 0; i < n; i++ {
+		outer.POSTSubStructSlice = append(outer.POSTSubStructSlice, inner)
+		outer.PUTSubStructSlice = append(outer.PUTSubStructSlice, inner)
+	}
+	data, err := json.Marshal(outer)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+func benchmarkCopy(b *testing.B, copyFunc func(interface{}, []byte, string) error) {
+	for _, n := range []int{10, 1000} {
+		data := largeOuterJSON(b, n)
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := copyFunc(&Outer{}, data, "POST"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCopyJSON(b *testing.B) {
+	benchmarkCopy(b, copyJSON)
+}
+
+func BenchmarkMapCopyJSON(b *testing.B) {
+	benchmarkCopy(b, mapCopyJSON)
+}
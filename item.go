@@ -2,6 +2,7 @@ package goaeoas
 
 import (
 	"encoding/json"
+	"fmt"
 	"reflect"
 	"sort"
 )
@@ -29,7 +30,12 @@ func (i *Item) SetName(s string) *Item {
 	return i
 }
 
+// AddLink adds l to the Item, unless l was created by Request.NewLink and the Policy of its route
+// doesn't allow the request to follow it from the Item.
 func (i *Item) AddLink(l Link) *Item {
+	if !linkAllowed(&l, i) {
+		return i
+	}
 	i.Links = append(i.Links, l)
 	return i
 }
@@ -39,6 +45,10 @@ func (i Item) MarshalJSON() ([]byte, error) {
 	for propertyValue.Kind() == reflect.Ptr {
 		propertyValue = propertyValue.Elem()
 	}
+	properties, err := hideWriteOnly(i.Properties)
+	if err != nil {
+		return nil, err
+	}
 	return json.Marshal(struct {
 		Name       string
 		Properties interface{}
@@ -46,7 +56,7 @@ func (i Item) MarshalJSON() ([]byte, error) {
 		Type       string
 		Links      Links
 	}{
-		Properties: i.Properties,
+		Properties: properties,
 		Desc:       i.Desc,
 		Type:       propertyValue.Type().Name(),
 		Links:      i.Links,
@@ -54,8 +64,177 @@ func (i Item) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// hideWriteOnly returns properties, or a copy of them with the WriteOnly fields zeroed if there are any,
+// so that they marshal like the DocType for GET describes them.
+func hideWriteOnly(properties interface{}) (interface{}, error) {
+	if properties == nil {
+		return nil, nil
+	}
+	val, changed, err := withoutWriteOnly(reflect.ValueOf(properties))
+	if err != nil || !changed {
+		return properties, err
+	}
+	return val.Interface(), nil
+}
+
+// withoutWriteOnly returns val, or a copy of it with the WriteOnly fields at any depth zeroed and true if
+// there were any. Only the parts of val containing them are copied, the rest is shared.
+// Values marshalling themselves are left as they are.
+func withoutWriteOnly(val reflect.Value) (reflect.Value, bool, error) {
+	if !mayHaveWriteOnly(val.Type()) || val.Type().Implements(jsonMarshalerType) {
+		return val, false, nil
+	}
+	switch val.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if val.IsNil() {
+			return val, false, nil
+		}
+		elem, changed, err := withoutWriteOnly(val.Elem())
+		if err != nil || !changed {
+			return val, false, err
+		}
+		if val.Kind() == reflect.Interface {
+			cpy := reflect.New(val.Type()).Elem()
+			cpy.Set(elem)
+			return cpy, true, nil
+		}
+		cpy := reflect.New(elem.Type())
+		cpy.Elem().Set(elem)
+		return cpy, true, nil
+	case reflect.Slice, reflect.Array:
+		var cpy reflect.Value
+		for i := 0; i < val.Len(); i++ {
+			elem, changed, err := withoutWriteOnly(val.Index(i))
+			if err != nil {
+				return val, false, err
+			}
+			if !changed {
+				continue
+			}
+			if !cpy.IsValid() {
+				if val.Kind() == reflect.Slice {
+					cpy = reflect.MakeSlice(val.Type(), val.Len(), val.Len())
+					reflect.Copy(cpy, val)
+				} else {
+					cpy = reflect.New(val.Type()).Elem()
+					cpy.Set(val)
+				}
+			}
+			cpy.Index(i).Set(elem)
+		}
+		return withCopy(val, cpy)
+	case reflect.Map:
+		var cpy reflect.Value
+		iter := val.MapRange()
+		for iter.Next() {
+			elem, changed, err := withoutWriteOnly(iter.Value())
+			if err != nil {
+				return val, false, err
+			}
+			if !changed {
+				continue
+			}
+			if !cpy.IsValid() {
+				cpy = reflect.MakeMapWithSize(val.Type(), val.Len())
+				for _, key := range val.MapKeys() {
+					cpy.SetMapIndex(key, val.MapIndex(key))
+				}
+			}
+			cpy.SetMapIndex(iter.Key(), elem)
+		}
+		return withCopy(val, cpy)
+	case reflect.Struct:
+		docType, err := NewDocType(val.Type(), "")
+		if err != nil {
+			return val, false, err
+		}
+		var cpy reflect.Value
+		for _, field := range docType.Fields {
+			fieldVal, found := field.lookup(val)
+			// Fields of unexported embedded structs can't be replaced.
+			if !found || !fieldVal.CanInterface() {
+				continue
+			}
+			replacement := reflect.Zero(fieldVal.Type())
+			if field.Access == WriteOnly {
+				if fieldVal.IsZero() {
+					continue
+				}
+			} else {
+				changed := false
+				if replacement, changed, err = withoutWriteOnly(fieldVal); err != nil {
+					return val, false, err
+				} else if !changed {
+					continue
+				}
+			}
+			if !cpy.IsValid() {
+				cpy = reflect.New(val.Type()).Elem()
+				cpy.Set(val)
+			}
+			dest, err := copyEmbedded(cpy, field.index)
+			if err != nil {
+				return val, false, err
+			}
+			dest.Set(replacement)
+		}
+		return withCopy(val, cpy)
+	}
+	return val, false, nil
+}
+
+// withCopy returns cpy and true if it's valid, and otherwise val and false.
+func withCopy(val, cpy reflect.Value) (reflect.Value, bool, error) {
+	if !cpy.IsValid() {
+		return val, false, nil
+	}
+	return cpy, true, nil
+}
+
+// copyEmbedded returns the field at index in the struct val, after replacing the non nil embedded
+// struct pointers on the way with pointers to copies, since they are shared with the original.
+// Embedded pointers to unexported structs can't be replaced, so fields in them can't be changed.
+func copyEmbedded(val reflect.Value, index []int) (reflect.Value, error) {
+	for i, idx := range index {
+		if i > 0 && val.Kind() == reflect.Ptr {
+			if !val.CanSet() {
+				return reflect.Value{}, fmt.Errorf("can't hide write only fields in embedded pointer to unexported struct %v", val.Type().Elem())
+			}
+			embedded := reflect.New(val.Type().Elem())
+			embedded.Elem().Set(val.Elem())
+			val.Set(embedded)
+			val = embedded.Elem()
+		}
+		val = val.Field(idx)
+	}
+	return val, nil
+}
+
+// mayHaveWriteOnly returns whether values of typ can contain structs.
+func mayHaveWriteOnly(typ reflect.Type) bool {
+	// Containers can contain themselves, like type T []T.
+	seen := map[reflect.Type]bool{}
+	for !seen[typ] {
+		seen[typ] = true
+		switch typ.Kind() {
+		case reflect.Struct, reflect.Interface:
+			return typ != timeType
+		case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+			if typ == keyType {
+				return false
+			}
+			typ = typ.Elem()
+		default:
+			return false
+		}
+	}
+	return false
+}
+
 func (i Item) HTMLNode() (*Node, error) {
-	selfLink := ""
+	view := &ItemView{
+		Item: &i,
+	}
 	restLinks := Links{}
 	for _, link := range i.Links {
 		if link.Rel == "self" {
@@ -63,61 +242,30 @@ func (i Item) HTMLNode() (*Node, error) {
 			if err != nil {
 				return nil, err
 			}
-			selfLink = u
+			view.SelfURL = u
 		} else {
 			restLinks = append(restLinks, link)
 		}
 	}
 	sort.Sort(restLinks)
-
-	itemNode := NewEl("section")
-	titleNode := itemNode.AddEl("header")
-	if selfLink == "" {
-		titleNode.AddText(i.Name)
-	} else {
-		titleNode.AddEl("a", "href", selfLink).AddText(i.Name)
-	}
-	if len(i.Desc) > 0 {
-		descNode := itemNode.AddEl("section")
-		descNode.AddEl("header").AddText("Description")
-		for _, part := range i.Desc {
-			if len(part) > 0 {
-				articleNode := descNode.AddEl("article")
-				articleNode.AddEl("header").AddText(part[0])
-				for _, paragraph := range part[1:] {
-					articleNode.AddEl("p").AddText(paragraph)
-				}
-			}
-		}
+	propertiesType := reflect.TypeOf(i.Properties)
+	for propertiesType != nil && propertiesType.Kind() == reflect.Ptr {
+		propertiesType = propertiesType.Elem()
 	}
-	propNode := itemNode.AddEl("section")
-	propNode.AddEl("header").AddText("Properties")
-	if list, ok := i.Properties.(List); ok {
-		listNode := propNode.AddEl("ul")
-		for _, item := range list {
-			itemNode, err := item.HTMLNode()
-			if err != nil {
-				return nil, err
-			}
-			listNode.AddEl("ul").AddNode(itemNode)
-		}
-	} else {
-		preNode := propNode.AddEl("article").AddEl("pre")
-		pretty, err := json.MarshalIndent(i.Properties, "  ", "  ")
+	for idx := range restLinks {
+		linkView, err := newLinkView(&restLinks[idx])
 		if err != nil {
 			return nil, err
 		}
-		preNode.AddText(string(pretty))
-	}
-	if len(restLinks) > 0 {
-		navNode := itemNode.AddEl("nav")
-		for _, link := range restLinks {
-			linkNode, err := link.HTMLNode()
-			if err != nil {
+		if linkView.Method == "PUT" && linkView.DocType != nil && linkView.DocType.typ == propertiesType {
+			if linkView.Values, err = FormValues(i.Properties, linkView.Method); err != nil {
 				return nil, err
 			}
-			navNode.AddNode(linkNode)
 		}
+		view.Links = append(view.Links, linkView)
+	}
+	if list, ok := i.Properties.(List); ok {
+		view.List = list
 	}
-	return itemNode, nil
+	return theme.Item(view)
 }
@@ -1,11 +1,10 @@
 package goaeoas
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"net/url"
 	"reflect"
-	"sync/atomic"
 )
 
 type LinkDecorator func(*Link, *url.URL) error
@@ -33,6 +32,8 @@ type Link struct {
 	baseScheme     string
 	baseHost       string
 	linkDecorators []LinkDecorator
+	ctx            context.Context
+	req            Request
 
 	Rel         string
 	Route       string
@@ -44,6 +45,15 @@ type Link struct {
 	Render      bool
 }
 
+// Context returns the context of the request that created the link using
+// Request.NewLink, for use by LinkDecorators.
+func (l *Link) Context() context.Context {
+	if l.ctx != nil {
+		return l.ctx
+	}
+	return context.Background()
+}
+
 func (l *Link) Resolve() (string, error) {
 	if l.URL != "" {
 		return l.URL, nil
@@ -56,6 +66,9 @@ func (l *Link) Resolve() (string, error) {
 	u.Scheme = l.baseScheme
 	u.Host = l.baseHost
 	for _, decorator := range l.linkDecorators {
+		if err := l.Context().Err(); err != nil {
+			return "", err
+		}
 		if err := decorator(l, u); err != nil {
 			return "", err
 		}
@@ -64,92 +77,11 @@ func (l *Link) Resolve() (string, error) {
 }
 
 func (l *Link) HTMLNode() (*Node, error) {
-	if l.Method == "" {
-		l.Method = "GET"
-	}
-	u, err := l.Resolve()
+	view, err := newLinkView(l)
 	if err != nil {
 		return nil, err
 	}
-	if l.Method == "GET" {
-		linkNode := NewEl("a", "href", u)
-		linkNode.AddText(l.Rel)
-		return linkNode, nil
-	}
-	var docType *DocType
-	if l.Type != nil {
-		docType, err = NewDocType(l.Type, l.Method)
-		if err != nil {
-			return nil, err
-		}
-	}
-	if (l.Method == "POST" || l.Method == "PUT") && docType != nil && len(docType.Fields) > 0 {
-		linkNode := NewEl("div")
-		formID := fmt.Sprintf("form%d", atomic.AddUint64(&nextElementID, 1))
-		linkNode.AddEl("form", "id", formID)
-		schema, err := docType.ToJSONSchema()
-		if err != nil {
-			return nil, err
-		}
-		schemaJSON, err := json.MarshalIndent(schema, "  ", "  ")
-		if err != nil {
-			return nil, err
-		}
-		resultID := atomic.AddUint64(&nextElementID, 1)
-		linkNode.AddEl("script").AddText(fmt.Sprintf(`
-$('#%s').jsonForm({
-  schema: %s,
-	form: [
-	  "*",
-		{
-			"type": "submit",
-			"title": %q
-		}
-	],
-	onSubmitValid: function(values) {
-		var req = new XMLHttpRequest();
-		req.addEventListener("readystatechange", function(ev) {
-			if (req.readyState == 4) {
-				if (req.status > 199 && req.status < 300) {
-					if ('%v' == 'true') {
-						$('body').append('<section><header>Result</header><article id="result%d"></article></section>');
-						$('#result%d').html(req.response);
-					}
-					alert("done");
-				} else {
-					alert(req.responseText);
-				}
-			}
-		});
-		req.open(%q, %q);
-		req.setRequestHeader("Content-Type", "application/json; charset=utf-8");
-		req.send(JSON.stringify(values));
-		return false;
-	}
-});
-`, formID, schemaJSON, l.Rel, l.Render, resultID, resultID, l.Method, u))
-		return linkNode, nil
-	}
-	linkNode := NewEl("div")
-	buttonID := atomic.AddUint64(&nextElementID, 1)
-	linkNode.AddEl("button", "id", fmt.Sprintf("button%d", buttonID)).AddText(l.Rel)
-	linkNode.AddEl("script").AddText(fmt.Sprintf(`
-document.getElementById("button%d").addEventListener("click", function(ev) {
-	var req = new XMLHttpRequest();
-	req.addEventListener("readystatechange", function(ev) {
-		if (req.readyState == 4) {
-			if (req.status > 199 && req.status < 300) {
-				alert("done");
-			} else {
-				alert(req.responseText);
-			}
-		}
-	});
-	req.open(%q, %q);
-  req.send();
-});
-`, buttonID, l.Method, u))
-	return linkNode, nil
+	return theme.Link(view)
 }
 
 func (l Link) MarshalJSON() ([]byte, error) {
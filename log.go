@@ -0,0 +1,127 @@
+package goaeoas
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LogLevel is the severity of a log message.
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarn:
+		return "WARN"
+	case LogError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+var (
+	logger        Logger = StdLogger{}
+	logLevel             = LogInfo
+	errorLogLevel        = DefaultErrorLogLevel
+)
+
+// Logger receives the log messages of goaeoas, with attributes as alternating keys and values.
+type Logger interface {
+	Log(ctx context.Context, level LogLevel, msg string, attrs ...interface{})
+}
+
+// StdLogger logs using the standard logger.
+type StdLogger struct{}
+
+func (StdLogger) Log(ctx context.Context, level LogLevel, msg string, attrs ...interface{}) {
+	parts := []string{level.String(), msg}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%v=%v", attrs[i], attrs[i+1]))
+	}
+	log.Print(strings.Join(parts, "\t"))
+}
+
+// SlogLogger logs using l.
+func SlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Log(ctx context.Context, level LogLevel, msg string, attrs ...interface{}) {
+	slogLevel := map[LogLevel]slog.Level{
+		LogDebug: slog.LevelDebug,
+		LogInfo:  slog.LevelInfo,
+		LogWarn:  slog.LevelWarn,
+		LogError: slog.LevelError,
+	}[level]
+	s.l.Log(ctx, slogLevel, msg, attrs...)
+}
+
+// SetLogger replaces the Logger, which by default is a StdLogger. nil disables logging.
+func SetLogger(l Logger) {
+	logger = l
+}
+
+// SetLogLevel drops log messages less severe than level. The default is LogInfo.
+func SetLogLevel(level LogLevel) {
+	logLevel = level
+}
+
+// DefaultErrorLogLevel logs the bodies of server errors as LogError and of other errors as LogInfo.
+func DefaultErrorLogLevel(status int) LogLevel {
+	if status >= 500 {
+		return LogError
+	}
+	return LogInfo
+}
+
+// SetErrorLogLevel replaces the function choosing the level error responses are logged with.
+func SetErrorLogLevel(f func(status int) LogLevel) {
+	errorLogLevel = f
+}
+
+func logMsg(ctx context.Context, level LogLevel, msg string, attrs ...interface{}) {
+	if logger == nil || level < logLevel {
+		return
+	}
+	logger.Log(ctx, level, msg, attrs...)
+}
+
+// logAccess, when deferred by the handler of a route, logs the response to the request.
+func logAccess(w *statusWriter, r *request, routeName string, start time.Time) {
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	principal := ""
+	if p, found := PrincipalKey.Get(r); found {
+		principal = p.Scheme + ":" + p.ID
+	}
+	logMsg(r.req.Context(), LogInfo, "request",
+		"method", r.req.Method,
+		"url", r.req.URL.String(),
+		"route", routeName,
+		"media", r.media,
+		"status", status,
+		"bytes", w.written,
+		"latency", time.Since(start),
+		"principal", principal)
+}
@@ -0,0 +1,214 @@
+package goaeoas
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	metrics Metrics
+	// DefaultLatencyBuckets are the upper bounds, in seconds, of the latency histograms of NewPrometheusMetrics.
+	DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	// DefaultSizeBuckets are the upper bounds, in bytes, of the response size histograms of NewPrometheusMetrics.
+	DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+)
+
+// MetricLabels identify the series a finished request is counted in.
+type MetricLabels struct {
+	// Route is the name of the route, like "User.Load".
+	Route string
+	// Method is the HTTP method of the request.
+	Method string
+	// StatusClass is the class of the response status, like "2xx".
+	StatusClass string
+	// Media is the negotiated media type, or "" if none could be negotiated.
+	Media string
+}
+
+// Metrics measures the requests handled by Handle.
+type Metrics interface {
+	// Started is called when a request to route using method starts.
+	Started(route, method string)
+	// Finished is called when a request started with the Route and Method of labels is responded to.
+	Finished(labels MetricLabels, latency time.Duration, bytes int64)
+}
+
+// SetMetrics makes Handle report to m, or stops reporting if m is nil.
+func SetMetrics(m Metrics) {
+	metrics = m
+}
+
+// startMetrics reports the start of the request to the current Metrics, and returns a
+// function reporting the end of it, for the handler of a route to defer.
+func startMetrics(w *statusWriter, r *request, routeName string) func() {
+	m := metrics
+	if m == nil {
+		return func() {}
+	}
+	start := time.Now()
+	m.Started(routeName, r.req.Method)
+	return func() {
+		status := w.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		labels := MetricLabels{
+			Route:       routeName,
+			Method:      r.req.Method,
+			StatusClass: fmt.Sprintf("%dxx", status/100),
+			Media:       r.media,
+		}
+		m.Finished(labels, time.Since(start), w.written)
+	}
+}
+
+// PrometheusMetrics keeps request counts, in-flight gauges and histograms of latencies and
+// response sizes, and serves them in the Prometheus text exposition format.
+type PrometheusMetrics struct {
+	mutex          sync.Mutex
+	latencyBuckets []float64
+	sizeBuckets    []float64
+	inFlight       map[[2]string]int64
+	requests       map[MetricLabels]*requestSeries
+}
+
+type requestSeries struct {
+	count   uint64
+	latency histogram
+	size    histogram
+}
+
+type histogram struct {
+	// counts has the observations in each bucket, not including the ones in lower buckets,
+	// and an extra last bucket for observations above all bounds.
+	counts []uint64
+	sum    float64
+}
+
+func (h *histogram) observe(bounds []float64, val float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(bounds)+1)
+	}
+	h.counts[sort.SearchFloat64s(bounds, val)]++
+	h.sum += val
+}
+
+// NewPrometheusMetrics returns an empty PrometheusMetrics using DefaultLatencyBuckets and DefaultSizeBuckets.
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		latencyBuckets: DefaultLatencyBuckets,
+		sizeBuckets:    DefaultSizeBuckets,
+		inFlight:       map[[2]string]int64{},
+		requests:       map[MetricLabels]*requestSeries{},
+	}
+}
+
+func (p *PrometheusMetrics) Started(route, method string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.inFlight[[2]string{route, method}]++
+}
+
+func (p *PrometheusMetrics) Finished(labels MetricLabels, latency time.Duration, bytes int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.inFlight[[2]string{labels.Route, labels.Method}]--
+	series, found := p.requests[labels]
+	if !found {
+		series = &requestSeries{}
+		p.requests[labels] = series
+	}
+	series.count++
+	series.latency.observe(p.latencyBuckets, latency.Seconds())
+	series.size.observe(p.sizeBuckets, float64(bytes))
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteTo(w)
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition format.
+func (p *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	buf := &strings.Builder{}
+
+	inFlightKeys := make([][2]string, 0, len(p.inFlight))
+	for key := range p.inFlight {
+		inFlightKeys = append(inFlightKeys, key)
+	}
+	sort.Slice(inFlightKeys, func(i, j int) bool {
+		return inFlightKeys[i][0] < inFlightKeys[j][0] || (inFlightKeys[i][0] == inFlightKeys[j][0] && inFlightKeys[i][1] < inFlightKeys[j][1])
+	})
+	buf.WriteString("# HELP goaeoas_requests_in_flight Requests being handled.\n# TYPE goaeoas_requests_in_flight gauge\n")
+	for _, key := range inFlightKeys {
+		fmt.Fprintf(buf, "goaeoas_requests_in_flight{%s} %d\n", promLabels("route", key[0], "method", key[1]), p.inFlight[key])
+	}
+
+	requestKeys := make([]MetricLabels, 0, len(p.requests))
+	for key := range p.requests {
+		requestKeys = append(requestKeys, key)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		a, b := requestKeys[i], requestKeys[j]
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		if a.StatusClass != b.StatusClass {
+			return a.StatusClass < b.StatusClass
+		}
+		return a.Media < b.Media
+	})
+	buf.WriteString("# HELP goaeoas_requests_total Requests handled.\n# TYPE goaeoas_requests_total counter\n")
+	for _, key := range requestKeys {
+		fmt.Fprintf(buf, "goaeoas_requests_total{%s} %d\n", key.promLabels(), p.requests[key].count)
+	}
+	buf.WriteString("# HELP goaeoas_request_duration_seconds Latency of handled requests.\n# TYPE goaeoas_request_duration_seconds histogram\n")
+	for _, key := range requestKeys {
+		writePromHistogram(buf, "goaeoas_request_duration_seconds", key.promLabels(), p.latencyBuckets, &p.requests[key].latency)
+	}
+	buf.WriteString("# HELP goaeoas_response_size_bytes Size of response bodies.\n# TYPE goaeoas_response_size_bytes histogram\n")
+	for _, key := range requestKeys {
+		writePromHistogram(buf, "goaeoas_response_size_bytes", key.promLabels(), p.sizeBuckets, &p.requests[key].size)
+	}
+
+	n, err := io.WriteString(w, buf.String())
+	return int64(n), err
+}
+
+func (l MetricLabels) promLabels() string {
+	return promLabels("route", l.Route, "method", l.Method, "status", l.StatusClass, "media", l.Media)
+}
+
+// promLabels formats name, value pairs as the labels of a Prometheus sample.
+func promLabels(pairs ...string) string {
+	parts := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", pairs[i], value))
+	}
+	return strings.Join(parts, ",")
+}
+
+func writePromHistogram(w io.Writer, name, labels string, bounds []float64, h *histogram) {
+	cumulative := uint64(0)
+	for i, bound := range bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	cumulative += h.counts[len(bounds)]
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, cumulative)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, cumulative)
+}
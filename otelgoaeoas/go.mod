@@ -0,0 +1,21 @@
+module github.com/zond/goaeoas/otelgoaeoas
+
+go 1.21
+
+require (
+	github.com/zond/goaeoas v0.0.0-20261018124050-703463ce4302
+	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel/trace v1.24.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.0 // indirect
+	github.com/gorilla/mux v1.7.4 // indirect
+	github.com/gorilla/schema v1.1.0 // indirect
+	golang.org/x/net v0.0.0-20220722155237-a158d28d115b // indirect
+	google.golang.org/appengine/v2 v2.0.6 // indirect
+	google.golang.org/protobuf v1.30.0 // indirect
+)
+
+// Develop against the goaeoas in this repository, consumers get the version required above.
+replace github.com/zond/goaeoas => ../
@@ -0,0 +1,104 @@
+// Package otelgoaeoas traces goaeoas requests using OpenTelemetry.
+//
+// Use it like
+//
+//	goaeoas.SetTracer(otelgoaeoas.Tracer(otel.Tracer("my-api")))
+package otelgoaeoas
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zond/goaeoas"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer returns a goaeoas.Tracer starting spans using t.
+func Tracer(t trace.Tracer) goaeoas.Tracer {
+	return tracer{t}
+}
+
+type tracer struct {
+	t trace.Tracer
+}
+
+// Start starts a server span for the request, or an internal span if ctx has a goaeoas span.
+func (t tracer) Start(ctx context.Context, name string) (context.Context, goaeoas.Span) {
+	kind := trace.SpanKindServer
+	if parent, ok := goaeoas.SpanFromContext(ctx).(span); ok {
+		// Contexts set by filters may carry ended child spans after the current span.
+		ctx = trace.ContextWithSpan(ctx, parent.s)
+		kind = trace.SpanKindInternal
+	} else if !trace.SpanContextFromContext(ctx).IsValid() {
+		if remote := goaeoas.RemoteSpanContext(ctx); remote.IsValid() {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, toOTel(remote))
+		}
+	}
+	ctx, s := t.t.Start(ctx, name, trace.WithSpanKind(kind))
+	return ctx, span{s}
+}
+
+type span struct {
+	s trace.Span
+}
+
+func (s span) SpanContext() goaeoas.SpanContext {
+	sc := s.s.SpanContext()
+	return goaeoas.SpanContext{
+		TraceID:    sc.TraceID(),
+		SpanID:     sc.SpanID(),
+		Sampled:    sc.IsSampled(),
+		TraceState: sc.TraceState().String(),
+	}
+}
+
+func (s span) SetAttributes(attrs ...interface{}) {
+	kvs := make([]attribute.KeyValue, 0, len(attrs)/2)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		kvs = append(kvs, keyValue(fmt.Sprint(attrs[i]), attrs[i+1]))
+	}
+	s.s.SetAttributes(kvs...)
+}
+
+func (s span) RecordError(err error) {
+	s.s.RecordError(err)
+	s.s.SetStatus(codes.Error, err.Error())
+}
+
+func (s span) End() {
+	s.s.End()
+}
+
+// keyValue converts key and val to an attribute, using the string form of val if it has no attribute type.
+func keyValue(key string, val interface{}) attribute.KeyValue {
+	switch v := val.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	}
+	return attribute.String(key, fmt.Sprint(val))
+}
+
+func toOTel(sc goaeoas.SpanContext) trace.SpanContext {
+	config := trace.SpanContextConfig{
+		TraceID: sc.TraceID,
+		SpanID:  sc.SpanID,
+		Remote:  true,
+	}
+	if sc.Sampled {
+		config.TraceFlags = trace.FlagsSampled
+	}
+	if state, err := trace.ParseTraceState(sc.TraceState); err == nil {
+		config.TraceState = state
+	}
+	return trace.NewSpanContext(config)
+}
@@ -0,0 +1,67 @@
+package otelgoaeoas
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zond/goaeoas"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+type kindTracer struct {
+	noop.Tracer
+	kinds []trace.SpanKind
+}
+
+func (k *kindTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	config := trace.NewSpanStartConfig(opts...)
+	k.kinds = append(k.kinds, config.SpanKind())
+	return k.Tracer.Start(ctx, name, opts...)
+}
+
+func TestTracer(t *testing.T) {
+	remote, ok := goaeoas.ParseTraceParent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "vendor=value")
+	if !ok {
+		t.Fatalf("invalid traceparent")
+	}
+	otelSC := toOTel(remote)
+	if !otelSC.IsValid() || !otelSC.IsRemote() || !otelSC.IsSampled() || otelSC.TraceState().Get("vendor") != "value" {
+		t.Errorf("got %+v, want the remote span context", otelSC)
+	}
+	// The noop tracer continues the span context of ctx, so the remote parent is visible in the span.
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), otelSC)
+	_, span := Tracer(noop.NewTracerProvider().Tracer("test")).Start(ctx, "route")
+	if got := span.SpanContext(); got != remote {
+		t.Errorf("got %+v, want %+v", got, remote)
+	}
+	// The current goaeoas span wins over later spans of ctx.
+	other, _ := goaeoas.ParseTraceParent("00-1af7651916cd43dd8448eb211c80319c-c7ad6b7169203331-01", "")
+	ctx = goaeoas.ContextWithSpan(trace.ContextWithRemoteSpanContext(ctx, toOTel(other)), span)
+	if _, child := Tracer(noop.NewTracerProvider().Tracer("test")).Start(ctx, "child"); child.SpanContext() != remote {
+		t.Errorf("got %+v, want a child of %+v", child.SpanContext(), remote)
+	}
+	// Only the root span of a request is a server span.
+	kinds := &kindTracer{}
+	ctx, root := Tracer(kinds).Start(context.Background(), "route")
+	Tracer(kinds).Start(goaeoas.ContextWithSpan(ctx, root), "handler")
+	if len(kinds.kinds) != 2 || kinds.kinds[0] != trace.SpanKindServer || kinds.kinds[1] != trace.SpanKindInternal {
+		t.Errorf("got %v, want server and internal spans", kinds.kinds)
+	}
+	for _, tc := range []struct {
+		val  interface{}
+		want attribute.Value
+	}{
+		{"a", attribute.StringValue("a")},
+		{true, attribute.BoolValue(true)},
+		{200, attribute.IntValue(200)},
+		{int64(3), attribute.Int64Value(3)},
+		{0.5, attribute.Float64Value(0.5)},
+		{[]int{1}, attribute.StringValue("[1]")},
+	} {
+		if got := keyValue("k", tc.val); got.Value != tc.want {
+			t.Errorf("%#v: got %v, want %v", tc.val, got.Value.Emit(), tc.want.Emit())
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package goaeoas
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine/v2/datastore"
+)
+
+const (
+	// PropertiesTimeFormat is used when rendering time.Time properties as HTML.
+	PropertiesTimeFormat = "2006-01-02 15:04:05 MST"
+)
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// PropertiesNode renders properties as structured HTML, using the GET DocType of
+// their type to decide which fields to show.
+// Structs become tables, slices become lists, and maps become definition lists.
+// Datastore keys of a kind that matches the Type of a registered Resource, and strings that
+// look like absolute URLs, become links.
+func PropertiesNode(properties interface{}) (*Node, error) {
+	return valueNode(reflect.ValueOf(properties))
+}
+
+func valueNode(val reflect.Value) (*Node, error) {
+	if !val.IsValid() {
+		return textEl("span", "null", "class", "null"), nil
+	}
+	typ := val.Type()
+	switch typ {
+	case keyType:
+		if val.IsNil() {
+			return textEl("span", "null", "class", "null"), nil
+		}
+		return keyNode(val.Interface().(*datastore.Key)), nil
+	case timeType:
+		t := val.Interface().(time.Time)
+		return textEl("time", t.Format(PropertiesTimeFormat), "datetime", t.Format(time.RFC3339)), nil
+	case durationType:
+		return textEl("span", val.Interface().(time.Duration).String(), "class", "duration"), nil
+	}
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if val.IsNil() {
+			return textEl("span", "null", "class", "null"), nil
+		}
+		return valueNode(val.Elem())
+	}
+	if typ.Implements(jsonMarshalerType) {
+		b, err := json.Marshal(val.Interface())
+		if err != nil {
+			return nil, err
+		}
+		return textEl("code", string(b)), nil
+	}
+	switch typ.Kind() {
+	case reflect.Struct:
+		return structNode(val)
+	case reflect.Slice, reflect.Array:
+		// Like encoding/json, only byte slices are base64, byte arrays are lists of numbers.
+		if typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.Uint8 {
+			return textEl("code", base64.StdEncoding.EncodeToString(val.Bytes()), "class", "bytes"), nil
+		}
+		listNode := NewEl("ul")
+		for i := 0; i < val.Len(); i++ {
+			elNode, err := valueNode(val.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			listNode.AddEl("li").AddNode(elNode)
+		}
+		return listNode, nil
+	case reflect.Map:
+		keys := val.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		dlNode := NewEl("dl")
+		for _, key := range keys {
+			dlNode.AddEl("dt").AddText(fmt.Sprint(key.Interface()))
+			elNode, err := valueNode(val.MapIndex(key))
+			if err != nil {
+				return nil, err
+			}
+			dlNode.AddEl("dd").AddNode(elNode)
+		}
+		return dlNode, nil
+	case reflect.String:
+		s := val.String()
+		if u, err := url.Parse(s); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+			return textEl("a", s, "href", s), nil
+		}
+		return textEl("span", s), nil
+	}
+	return textEl("span", fmt.Sprint(val.Interface())), nil
+}
+
+func structNode(val reflect.Value) (*Node, error) {
+	fields, err := NewDocFields(val.Type(), "GET")
+	if err != nil {
+		return nil, err
+	}
+	tableNode := NewEl("table", "class", "properties")
+	bodyNode := tableNode.AddEl("tbody")
+	for _, field := range fields {
+		fieldVal, found := field.lookup(val)
+		if !found {
+			continue
+		}
+		rowNode := bodyNode.AddEl("tr")
+		rowNode.AddEl("th").AddText(field.Name)
+		fieldNode, err := valueNode(fieldVal)
+		if err != nil {
+			return nil, err
+		}
+		rowNode.AddEl("td").AddNode(fieldNode)
+	}
+	return tableNode, nil
+}
+
+func keyNode(key *datastore.Key) *Node {
+	if u := keyURL(key); u != nil {
+		return textEl("a", key.String(), "href", u.String())
+	}
+	return textEl("code", key.String())
+}
+
+// keyURL returns the Load URL of the registered Resource whose Type has the same name as the kind of key.
+func keyURL(key *datastore.Key) *url.URL {
+	if router == nil {
+		return nil
+	}
+	for _, res := range resources {
+		if res.Load == nil || res.Type.Name() != key.Kind() {
+			continue
+		}
+		route := router.Get(res.Route(Load))
+		if route == nil {
+			return nil
+		}
+		pt, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		match := pathElementReg.FindStringSubmatch(pt)
+		if match == nil || pathElementReg.MatchString(match[3]) {
+			return nil
+		}
+		u, err := route.URL(strings.SplitN(match[2], ":", 2)[0], key.Encode())
+		if err != nil {
+			return nil
+		}
+		return u
+	}
+	return nil
+}
+
+func textEl(s, text string, attrs ...string) *Node {
+	el := NewEl(s, attrs...)
+	el.AddText(text)
+	return el
+}
@@ -0,0 +1,248 @@
+package goaeoas
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/appengine/v2"
+)
+
+var (
+	rateLimit      *RateLimit
+	rateLimitStore RateLimitStore = NewMemoryRateLimitStore()
+	trustedProxies int
+)
+
+// RateLimit allows Requests requests per Period for each key, in bursts of up to Burst requests.
+// Requests and Period must be positive.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+	// Burst is the size of the bucket, Requests if zero.
+	Burst int
+	// Key returns the key of a request, RemoteIPKey if nil. Limits without Key are applied before
+	// authenticating, others after, and then failed authentications count against the RemoteIPKey.
+	Key func(r Request) string
+}
+
+// validate panics if l can't limit anything, since its bucket would never refill or never hold a request.
+func (l *RateLimit) validate() {
+	if l == nil {
+		return
+	}
+	if l.Requests <= 0 || l.Period <= 0 || l.Burst < 0 {
+		panic(fmt.Errorf("rate limit of %v requests per %v in bursts of %v needs positive Requests and Period, and a non negative Burst", l.Requests, l.Period, l.Burst))
+	}
+}
+
+// capacity returns the size of the bucket of l.
+func (l *RateLimit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// rate returns how many requests per second l refills.
+func (l *RateLimit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// RateLimitResult is the outcome of taking a request from a bucket.
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining is the number of requests left in the bucket.
+	Remaining int
+	// Reset is when the bucket will be full again.
+	Reset time.Duration
+	// RetryAfter is when the next request will be allowed, if this one wasn't.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the buckets of rate limited keys. Implement it on top of a shared
+// database or cache to limit requests across instances.
+type RateLimitStore interface {
+	// Take takes a request from the bucket of key, which follows limit.
+	Take(ctx context.Context, key string, limit *RateLimit) (RateLimitResult, error)
+}
+
+// SetRateLimit limits the requests of all routes, unless overridden by Resource.RateLimits
+// or Lister.RateLimit. Routes without own limits share buckets. nil disables the limit.
+func SetRateLimit(l *RateLimit) {
+	l.validate()
+	rateLimit = l
+}
+
+// SetRateLimitStore replaces the store of the rate limits, which by default is a MemoryRateLimitStore.
+func SetRateLimitStore(store RateLimitStore) {
+	rateLimitStore = store
+}
+
+// SetTrustedProxies makes RemoteIPKey use the X-Forwarded-For header, which n proxies in front of the
+// server append the addresses of their clients to. The default, zero, ignores the header, since
+// clients can send anything in it.
+func SetTrustedProxies(n int) {
+	if n < 0 {
+		panic(fmt.Errorf("negative number of trusted proxies %v", n))
+	}
+	trustedProxies = n
+}
+
+// RemoteIPKey returns the IP of the client of r. On App Engine that's the X-Appengine-User-IP header,
+// elsewhere the address the outermost of the SetTrustedProxies proxies added to X-Forwarded-For, or
+// the remote address of the connection.
+func RemoteIPKey(r Request) string {
+	req := r.Req()
+	if appengine.IsAppEngine() {
+		if ip := req.Header.Get("X-Appengine-User-IP"); ip != "" {
+			return ip
+		}
+	}
+	if trustedProxies > 0 {
+		forwarded := []string{}
+		for _, header := range req.Header.Values("X-Forwarded-For") {
+			for _, addr := range strings.Split(header, ",") {
+				forwarded = append(forwarded, strings.TrimSpace(addr))
+			}
+		}
+		// Requests with fewer addresses didn't pass all the proxies.
+		if len(forwarded) >= trustedProxies {
+			return forwarded[len(forwarded)-trustedProxies]
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// PrincipalRateLimitKey returns the ID of the Principal of r, or the RemoteIPKey of unauthenticated requests.
+func PrincipalRateLimitKey(r Request) string {
+	if principal, found := PrincipalKey.Get(r); found {
+		return principal.Scheme + ":" + principal.ID
+	}
+	return RemoteIPKey(r)
+}
+
+// HeaderRateLimitKey returns a key function using the value of header, like an API key,
+// or the RemoteIPKey of requests without it.
+func HeaderRateLimitKey(header string) func(Request) string {
+	return func(r Request) string {
+		if val := r.Req().Header.Get(header); val != "" {
+			return header + ":" + val
+		}
+		return RemoteIPKey(r)
+	}
+}
+
+// routeRateLimit returns the limit of the route named routeName, if any, and the name of its buckets.
+func routeRateLimit(routeName string, routeLimit *RateLimit) (*RateLimit, string) {
+	if routeLimit != nil {
+		return routeLimit, routeName
+	}
+	return rateLimit, ""
+}
+
+// limitRate takes a request of r from the bucket of its key among the buckets named bucketsName, adds
+// RateLimit headers to w, and returns a 429 error if the bucket is empty. keyFunc overrides limit.Key if not nil.
+func limitRate(w ResponseWriter, r Request, limit *RateLimit, bucketsName string, keyFunc func(Request) string) error {
+	if limit == nil {
+		return nil
+	}
+	if keyFunc == nil {
+		if keyFunc = limit.Key; keyFunc == nil {
+			keyFunc = RemoteIPKey
+		}
+	}
+	result, err := rateLimitStore.Take(r.Context(), bucketsName+"|"+keyFunc(r), limit)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("RateLimit-Limit", fmt.Sprint(int(limit.capacity())))
+	w.Header().Set("RateLimit-Remaining", fmt.Sprint(result.Remaining))
+	w.Header().Set("RateLimit-Reset", fmt.Sprint(seconds(result.Reset)))
+	if !result.Allowed {
+		w.Header().Set("Retry-After", fmt.Sprint(seconds(result.RetryAfter)))
+		return HTTPErr{Body: "too many requests", Status: http.StatusTooManyRequests}
+	}
+	return nil
+}
+
+// seconds returns d in whole seconds, rounded up.
+func seconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// MemoryRateLimitStore keeps token buckets in memory, limiting the requests to each instance.
+type MemoryRateLimitStore struct {
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	sweepAt int
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	limit   *RateLimit
+}
+
+// refill adds the tokens refilled since the last update of b.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.limit.capacity(), b.tokens+now.Sub(b.updated).Seconds()*b.limit.rate())
+	b.updated = now
+}
+
+// NewMemoryRateLimitStore returns an empty MemoryRateLimitStore.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: map[string]*tokenBucket{},
+		sweepAt: 1024,
+	}
+}
+
+func (m *MemoryRateLimitStore) Take(ctx context.Context, key string, limit *RateLimit) (RateLimitResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	if len(m.buckets) >= m.sweepAt {
+		m.sweep(now)
+	}
+	bucket, found := m.buckets[key]
+	if !found {
+		bucket = &tokenBucket{
+			tokens:  limit.capacity(),
+			updated: now,
+		}
+		m.buckets[key] = bucket
+	}
+	bucket.limit = limit
+	bucket.refill(now)
+	result := RateLimitResult{}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - bucket.tokens) / limit.rate() * float64(time.Second))
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = time.Duration((limit.capacity() - bucket.tokens) / limit.rate() * float64(time.Second))
+	return result, nil
+}
+
+// sweep forgets the buckets that are full, since they behave like new ones.
+func (m *MemoryRateLimitStore) sweep(now time.Time) {
+	for key, bucket := range m.buckets {
+		bucket.refill(now)
+		if bucket.tokens >= bucket.limit.capacity() {
+			delete(m.buckets, key)
+		}
+	}
+	m.sweepAt = 2*len(m.buckets) + 1024
+}
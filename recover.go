@@ -0,0 +1,98 @@
+package goaeoas
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+var (
+	errorReporter ErrorReporter = logErrorReporter{}
+)
+
+// PanicReport describes a panic recovered while handling a request.
+type PanicReport struct {
+	// Value is what the code panicked with.
+	Value interface{}
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+	// Route is the name of the route handling the request.
+	Route string
+	// Request is the panicking request.
+	Request *http.Request
+	// Principal is the authenticated caller, if any.
+	Principal *Principal
+}
+
+func (p *PanicReport) String() string {
+	return fmt.Sprintf("%v\t%v\t%v panicked: %v\n%s", p.Request.Method, p.Request.URL.String(), p.Route, p.Value, p.Stack)
+}
+
+// ErrorReporter gets the panics Handle recovers from, which are responded to with a 500.
+type ErrorReporter interface {
+	ReportPanic(report *PanicReport)
+}
+
+type logErrorReporter struct{}
+
+func (logErrorReporter) ReportPanic(report *PanicReport) {
+	logMsg(report.Request.Context(), LogError, "panic",
+		"method", report.Request.Method,
+		"url", report.Request.URL.String(),
+		"route", report.Route,
+		"value", report.Value,
+		"stack", string(report.Stack))
+}
+
+// SetErrorReporter replaces the ErrorReporter, which by default logs the panics and their stack traces
+// as LogError.
+func SetErrorReporter(reporter ErrorReporter) {
+	errorReporter = reporter
+}
+
+// statusWriter records what is written to the wrapped http.ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.written += int64(n)
+	return n, err
+}
+
+// recoverPanic, when deferred by the handler of a route, reports panics of the handler and
+// responds with a 500 unless the response is already started.
+func recoverPanic(w *statusWriter, r *request, routeName string) {
+	val := recover()
+	if val == nil {
+		return
+	}
+	r.endSpans(fmt.Errorf("panic: %v", val))
+	if val == http.ErrAbortHandler {
+		panic(val)
+	}
+	report := &PanicReport{
+		Value:   val,
+		Stack:   debug.Stack(),
+		Route:   routeName,
+		Request: r.req,
+	}
+	report.Principal, _ = PrincipalKey.Get(r)
+	errorReporter.ReportPanic(report)
+	if w.status == 0 {
+		HTTPError(w, r.req, fmt.Errorf("internal error"))
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -18,15 +19,32 @@ import (
 var (
 	pathElementReg = regexp.MustCompile("^([^{]*\\{)([^}]+)(\\}.*$)")
 	resources      = []*Resource{}
+	routeOpts      = map[string]*routeOptions{}
 	nonAlpha       = regexp.MustCompile("[^a-zA-Z0-9]")
 )
 
+// routeOptions holds what Handle needs to know about routes registered by HandleResource.
+type routeOptions struct {
+	bodyType    reflect.Type
+	maxBodySize int64
+	strict      bool
+	auth        AuthRequirement
+	rateLimit   *RateLimit
+	policy      Policy
+	// loadTarget loads the Item a policy decides about, nil if the route has none.
+	loadTarget resourceHandler
+}
+
 type Lister struct {
 	Path    string
 	Route   string
 	Handler func(ResponseWriter, Request) error
 	// QueryParams document the query params this lister handles, and are only used when generating code or documentation.
 	QueryParams []string
+	// Auth is the authentication the lister requires.
+	Auth AuthRequirement
+	// RateLimit overrides the rate limit set with SetRateLimit for the lister.
+	RateLimit *RateLimit
 }
 
 type Resource struct {
@@ -41,14 +59,42 @@ type Resource struct {
 
 	Type        reflect.Type
 	RenderLinks bool
+
+	// MaxBodySize overrides the max body size set with SetMaxBodySize for the routes of some methods.
+	// Negative values mean no limit.
+	MaxBodySize map[Method]int64
+
+	// Strict makes Copy reject request bodies with fields that are unknown or not writable
+	// using the method of the request, instead of skipping the fields.
+	Strict bool
+
+	// Auth is the authentication required by the routes of some methods, see SetAuthenticators.
+	Auth map[Method]AuthRequirement
+
+	// Policies decide who may use some methods. Handle checks them before running the handler,
+	// and Item.AddLink leaves out links to methods the caller may not use.
+	Policies map[Method]Policy
+
+	// RateLimits override the rate limit set with SetRateLimit for the routes of some methods.
+	RateLimits map[Method]*RateLimit
+
+	handlers  map[Method]resourceHandler
+	bodyTypes map[Method]reflect.Type
 }
 
-func createRoute(ro *mux.Router, re *Resource, meth Method, rType reflect.Type) reflect.Type {
-	var fVal reflect.Value
-	fVal, rType = validateResourceFunc(re.resourceFunc(meth), rType)
-	re.Type = rType
+// Policy decides whether the caller of r may use a method of a Resource on target.
+// When checked by Handle, target is what the Load handler of the Resource returns for the same
+// route params, or nil for Create and for Resources without Load. When checked by Item.AddLink,
+// target is the Item the link is added to.
+type Policy func(r Request, target *Item) (bool, error)
+
+// resourceHandler is what the routes of a Resource call, regardless of how the Resource was registered.
+type resourceHandler func(ResponseWriter, Request) (Itemer, error)
+
+func createRoute(ro *mux.Router, re *Resource, meth Method) {
+	handler := re.handlers[meth]
 	if re.CreatePath == "" {
-		re.CreatePath = fmt.Sprintf("/%s", rType.Name())
+		re.CreatePath = fmt.Sprintf("/%s", re.Type.Name())
 	}
 	if re.FullPath == "" {
 		re.FullPath = fmt.Sprintf("%s/{id}", re.CreatePath)
@@ -59,6 +105,21 @@ func createRoute(ro *mux.Router, re *Resource, meth Method, rType reflect.Type)
 	} else {
 		pattern = re.FullPath
 	}
+	re.RateLimits[meth].validate()
+	opts := &routeOptions{
+		maxBodySize: re.MaxBodySize[meth],
+		strict:      re.Strict,
+		auth:        re.Auth[meth],
+		rateLimit:   re.RateLimits[meth],
+		policy:      re.Policies[meth],
+	}
+	if meth == Create || meth == Update {
+		opts.bodyType = re.BodyType(meth)
+	}
+	if meth != Create {
+		opts.loadTarget = re.handlers[Load]
+	}
+	routeOpts[re.Route(meth)] = opts
 	Handle(
 		ro,
 		pattern,
@@ -67,39 +128,227 @@ func createRoute(ro *mux.Router, re *Resource, meth Method, rType reflect.Type)
 		},
 		re.Route(meth),
 		func(w ResponseWriter, r Request) error {
-			resultVals := fVal.Call([]reflect.Value{reflect.ValueOf(w), reflect.ValueOf(r)})
-			if !resultVals[1].IsNil() {
-				return resultVals[1].Interface().(error)
+			// Policies of Load routes already loaded the result.
+			result, found := targetKey.Get(r)
+			if !found || meth != Load {
+				var err error
+				if result, err = handler(w, r); err != nil {
+					return err
+				}
 			}
-			if !resultVals[0].IsNil() {
-				w.SetContent(resultVals[0].Interface().(Itemer).Item(r))
+			if result != nil {
+				w.SetContent(result.Item(r))
 			}
 			return nil
 		},
 	)
-	return rType
 }
 
-func HandleResource(ro *mux.Router, re *Resource) {
-	var rType reflect.Type
-	if re.Create != nil {
-		rType = createRoute(ro, re, Create, rType)
+// reflectHandler validates f as the meth handler of a Resource of type needType, and wraps it in a resourceHandler.
+func reflectHandler(f interface{}, needType reflect.Type, meth Method) (resourceHandler, reflect.Type, reflect.Type) {
+	fVal, rType, bodyType := validateResourceFunc(f, needType, meth)
+	return func(w ResponseWriter, r Request) (Itemer, error) {
+		args := []reflect.Value{reflect.ValueOf(w), reflect.ValueOf(r)}
+		if bodyType != nil {
+			body := reflect.New(bodyType)
+			if err := decodeBody(r, body.Interface(), meth); err != nil {
+				return nil, err
+			}
+			args = append(args, body)
+		}
+		resultVals := fVal.Call(args)
+		if !resultVals[1].IsNil() {
+			return nil, resultVals[1].Interface().(error)
+		}
+		if resultVals[0].IsNil() {
+			return nil, nil
+		}
+		return resultVals[0].Interface().(Itemer), nil
+	}, rType, bodyType
+}
+
+// Validator is implemented by request bodies that check themselves after being decoded
+// for handlers taking a decoded body.
+type Validator interface {
+	Validate(Request) error
+}
+
+// decodeBody copies the request body into the new value dest, filtered by the HTTP method of meth, and
+// validates the result. Since there is nothing to compare them with, WriteOnce fields are ignored.
+func decodeBody(r Request, dest interface{}, meth Method) error {
+	if err := copyReader(dest, r, r.Req().Body, meth.HTTPMethod(), true); err != nil {
+		if _, status := errorStatus(err); status != http.StatusInternalServerError {
+			return err
+		}
+		return HTTPErr{
+			Body:   err.Error(),
+			Status: http.StatusBadRequest,
+		}
 	}
-	if re.Update != nil {
-		rType = createRoute(ro, re, Update, rType)
+	if validator, ok := dest.(Validator); ok {
+		return validator.Validate(r)
 	}
-	if re.Delete != nil {
-		rType = createRoute(ro, re, Delete, rType)
+	return nil
+}
+
+// typedBodyHandler wraps f in a resourceHandler that decodes the request body into a new T
+// before calling f.
+func typedBodyHandler[T Itemer](f func(ResponseWriter, Request, T) (T, error), meth Method) resourceHandler {
+	bodyType := reflect.TypeOf((*T)(nil)).Elem()
+	if bodyType.Kind() != reflect.Ptr || bodyType.Elem().Kind() != reflect.Struct {
+		panic(fmt.Errorf("%v isn't a pointer to a struct, and can't be used as a body", bodyType))
+	}
+	return typedHandler(func(w ResponseWriter, r Request) (T, error) {
+		body := reflect.New(bodyType.Elem()).Interface().(T)
+		if err := decodeBody(r, body, meth); err != nil {
+			var zero T
+			return zero, err
+		}
+		return f(w, r, body)
+	})
+}
+
+// typedHandler wraps f in a resourceHandler without calling it via reflection.
+func typedHandler[T Itemer](f func(ResponseWriter, Request) (T, error)) resourceHandler {
+	return func(w ResponseWriter, r Request) (Itemer, error) {
+		result, err := f(w, r)
+		if err != nil {
+			return nil, err
+		}
+		if isNil(result) {
+			return nil, nil
+		}
+		return result, nil
 	}
-	if re.Load != nil {
-		createRoute(ro, re, Load, rType)
+}
+
+func isNil(i interface{}) bool {
+	if i == nil {
+		return true
+	}
+	val := reflect.ValueOf(i)
+	switch val.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan:
+		return val.IsNil()
+	}
+	return false
+}
+
+func HandleResource(ro *mux.Router, re *Resource) {
+	if re.handlers == nil {
+		re.handlers = map[Method]resourceHandler{}
+		var rType, bodyType reflect.Type
+		for _, meth := range []Method{Create, Update, Delete, Load} {
+			if f := re.resourceFunc(meth); f != nil {
+				re.handlers[meth], rType, bodyType = reflectHandler(f, rType, meth)
+				if bodyType != nil {
+					if re.bodyTypes == nil {
+						re.bodyTypes = map[Method]reflect.Type{}
+					}
+					re.bodyTypes[meth] = bodyType
+				}
+			}
+		}
+		re.Type = rType
+	}
+	for _, meth := range []Method{Create, Update, Delete, Load} {
+		if re.handlers[meth] != nil {
+			createRoute(ro, re, meth)
+		}
 	}
 	for _, lister := range re.Listers {
+		lister.RateLimit.validate()
+		routeOpts[lister.Route] = &routeOptions{
+			auth:      lister.Auth,
+			rateLimit: lister.RateLimit,
+		}
 		Handle(ro, lister.Path, []string{"GET"}, lister.Route, lister.Handler)
 	}
 	resources = append(resources, re)
 }
 
+// TypedResource describes a Resource whose handlers return T, which lets the
+// compiler check their signatures and avoids reflection when calling them.
+type TypedResource[T Itemer] struct {
+	Create  func(ResponseWriter, Request) (T, error)
+	Update  func(ResponseWriter, Request) (T, error)
+	Delete  func(ResponseWriter, Request) (T, error)
+	Load    func(ResponseWriter, Request) (T, error)
+	Listers []Lister
+
+	// CreateBody and UpdateBody can be used instead of Create and Update, and get the request body
+	// already decoded into a new T, filtered by method and validated if T implements Validator.
+	// T must be a pointer to a struct to use them. Since a new T has no current values to compare
+	// with, WriteOnce fields sent to UpdateBody are ignored instead of checked.
+	CreateBody func(ResponseWriter, Request, T) (T, error)
+	UpdateBody func(ResponseWriter, Request, T) (T, error)
+
+	FullPath   string
+	CreatePath string
+
+	RenderLinks bool
+
+	MaxBodySize map[Method]int64
+	Strict      bool
+	Auth        map[Method]AuthRequirement
+	Policies    map[Method]Policy
+	RateLimits  map[Method]*RateLimit
+}
+
+// NewResource registers the routes of tr on ro, like HandleResource does for a Resource,
+// and returns the resulting Resource for use when creating links and generating code.
+func NewResource[T Itemer](ro *mux.Router, tr TypedResource[T]) *Resource {
+	rType := reflect.TypeOf((*T)(nil)).Elem()
+	for rType.Kind() == reflect.Ptr {
+		rType = rType.Elem()
+	}
+	re := &Resource{
+		Listers:     tr.Listers,
+		FullPath:    tr.FullPath,
+		CreatePath:  tr.CreatePath,
+		Type:        rType,
+		RenderLinks: tr.RenderLinks,
+		MaxBodySize: tr.MaxBodySize,
+		Strict:      tr.Strict,
+		Auth:        tr.Auth,
+		Policies:    tr.Policies,
+		RateLimits:  tr.RateLimits,
+		handlers:    map[Method]resourceHandler{},
+	}
+	if tr.Create != nil && tr.CreateBody != nil {
+		panic(fmt.Errorf("only one of Create and CreateBody allowed"))
+	}
+	if tr.Update != nil && tr.UpdateBody != nil {
+		panic(fmt.Errorf("only one of Update and UpdateBody allowed"))
+	}
+	if tr.Create != nil {
+		re.Create = tr.Create
+		re.handlers[Create] = typedHandler(tr.Create)
+	}
+	if tr.CreateBody != nil {
+		re.Create = tr.CreateBody
+		re.handlers[Create] = typedBodyHandler(tr.CreateBody, Create)
+	}
+	if tr.Update != nil {
+		re.Update = tr.Update
+		re.handlers[Update] = typedHandler(tr.Update)
+	}
+	if tr.UpdateBody != nil {
+		re.Update = tr.UpdateBody
+		re.handlers[Update] = typedBodyHandler(tr.UpdateBody, Update)
+	}
+	if tr.Delete != nil {
+		re.Delete = tr.Delete
+		re.handlers[Delete] = typedHandler(tr.Delete)
+	}
+	if tr.Load != nil {
+		re.Load = tr.Load
+		re.handlers[Load] = typedHandler(tr.Load)
+	}
+	HandleResource(ro, re)
+	return re
+}
+
 func (r *Resource) writeJavaListerMeth(lister Lister, w io.Writer) error {
 	ms, err := r.methodSignature(Load, lister.Path, lister.Route, true, lister.QueryParams)
 	if err != nil {
@@ -116,10 +365,9 @@ func (r *Resource) methodSignature(meth Method, pathTemplate string, route strin
 	buf := &bytes.Buffer{}
 	args := []string{}
 	switch meth {
-	case Create:
-		args = append(args, fmt.Sprintf("@Body %s %s", r.Type.Name(), strings.ToLower(r.Type.Name())))
-	case Update:
-		args = append(args, fmt.Sprintf("@Body %s %s", r.Type.Name(), strings.ToLower(r.Type.Name())))
+	case Create, Update:
+		bodyType := r.BodyType(meth)
+		args = append(args, fmt.Sprintf("@Body %s %s", bodyType.Name(), strings.ToLower(bodyType.Name())))
 	}
 	for match := pathElementReg.FindStringSubmatch(pathTemplate); match != nil; match = pathElementReg.FindStringSubmatch(pathTemplate) {
 		args = append(args, fmt.Sprintf("@Path(\"%s\") String %s", match[2], match[2]))
@@ -162,7 +410,20 @@ func (r *Resource) toJavaClasses(pkg, meth string) (map[string]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	return docType.ToJavaClasses(pkg, meth)
+	classes, err := docType.ToJavaClasses(pkg, meth)
+	if err != nil {
+		return nil, err
+	}
+	for _, bodyType := range r.bodyTypes {
+		bodyDocType, err := NewDocType(bodyType, meth)
+		if err != nil {
+			return nil, err
+		}
+		if err := bodyDocType.populateJavaClasses(classes, pkg, meth); err != nil {
+			return nil, err
+		}
+	}
+	return classes, nil
 }
 
 func (r *Resource) toJavaInterface(pkg string) (string, error) {
@@ -221,13 +482,22 @@ func (r *Resource) Route(meth Method) string {
 	return r.Type.Name() + "." + meth.String()
 }
 
+// BodyType returns the type of the request bodies of meth, which is the Type of the
+// Resource unless the handler of meth takes a decoded body of another type.
+func (r *Resource) BodyType(meth Method) reflect.Type {
+	if bodyType, found := r.bodyTypes[meth]; found {
+		return bodyType
+	}
+	return r.Type
+}
+
 func (r *Resource) Link(rel string, meth Method, routeParams []string) Link {
 	return Link{
 		Rel:         rel,
 		Route:       r.Route(meth),
 		RouteParams: routeParams,
 		Method:      meth.HTTPMethod(),
-		Type:        r.Type,
+		Type:        r.BodyType(meth),
 		Render:      r.RenderLinks,
 	}
 }
@@ -236,13 +506,23 @@ func (r *Resource) URL(meth Method, id interface{}) (*url.URL, error) {
 	return router.Get(r.Route(meth)).URL("id", fmt.Sprint(id))
 }
 
-func validateResourceFunc(f interface{}, needType reflect.Type) (fVal reflect.Value, returnType reflect.Type) {
+func validateResourceFunc(f interface{}, needType reflect.Type, meth Method) (fVal reflect.Value, returnType reflect.Type, bodyType reflect.Type) {
 	fVal = reflect.ValueOf(f)
 	fTyp := fVal.Type()
 	if fTyp.Kind() != reflect.Func {
 		panic(fmt.Errorf("%#v isn't a func", f))
 	}
-	if fTyp.NumIn() != 2 {
+	if meth == Create || meth == Update {
+		if fTyp.NumIn() != 2 && fTyp.NumIn() != 3 {
+			panic(fmt.Errorf("%#v isn't a func with two or three params", f))
+		}
+		if fTyp.NumIn() == 3 {
+			if fTyp.In(2).Kind() != reflect.Ptr || fTyp.In(2).Elem().Kind() != reflect.Struct {
+				panic(fmt.Errorf("%#v isn't a func with a pointer to a struct as its third param", f))
+			}
+			bodyType = fTyp.In(2).Elem()
+		}
+	} else if fTyp.NumIn() != 2 {
 		panic(fmt.Errorf("%#v isn't a func with two params", f))
 	}
 	if !fTyp.In(0).Implements(responseWriterType) {
@@ -267,7 +547,7 @@ func validateResourceFunc(f interface{}, needType reflect.Type) (fVal reflect.Va
 	if needType != nil && needType != returnType {
 		panic(fmt.Errorf("%#v and %#v not the same resource type", needType, returnType))
 	}
-	return fVal, returnType
+	return fVal, returnType, bodyType
 }
 
 func GenerateJava(pkg string) (map[string]string, error) {
@@ -1,13 +1,32 @@
 package goaeoas
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 var (
-	userResource *Resource
+	userResource    *Resource
+	noteResource    *Resource
+	memoResource    *Resource
+	taskResource    *Resource
+	profileResource *Resource
+	secretResource  *Resource
+	docResource     *Resource
 )
 
 const (
@@ -63,6 +82,192 @@ func listFriends(w ResponseWriter, r Request) error {
 	return nil
 }
 
+type Note struct {
+	Title string `methods:"POST,PUT"`
+	Count int    `methods:"POST"`
+}
+
+func (n *Note) Item(r Request) *Item {
+	return NewItem(n).AddLink(r.NewLink(noteResource.Link("self", Load, []string{"id", "1"})))
+}
+
+func copyNote(r Request, method string) (*Note, error) {
+	n := &Note{}
+	if err := Copy(n, r, method); err != nil {
+		return nil, err
+	}
+	if n.Title == "" {
+		return nil, ValidationErr{Fields: map[string]string{"Title": "required"}}
+	}
+	return n, nil
+}
+
+func createNote(w ResponseWriter, r Request) (*Note, error) {
+	return copyNote(r, "POST")
+}
+
+func updateNote(w ResponseWriter, r Request) (*Note, error) {
+	return copyNote(r, "PUT")
+}
+
+func loadNote(w ResponseWriter, r Request) (*Note, error) {
+	return &Note{}, nil
+}
+
+type Memo struct {
+	Text string `methods:"POST"`
+}
+
+func (m *Memo) Item(r Request) *Item {
+	return NewItem(m).SetName(m.Text)
+}
+
+func loadMemo(w ResponseWriter, r Request) (*Memo, error) {
+	return &Memo{Text: r.Vars()["id"]}, nil
+}
+
+func listMemos(w ResponseWriter, r Request) error {
+	w.SetContent(NewItem(List{}))
+	return nil
+}
+
+func createMemo(w ResponseWriter, r Request, body *Memo) (*Memo, error) {
+	return body, nil
+}
+
+type TaskInput struct {
+	Title string `methods:"POST"`
+}
+
+func (t *TaskInput) Validate(r Request) error {
+	if t.Title == "" {
+		return ValidationErr{Fields: map[string]string{"Title": "required"}}
+	}
+	return nil
+}
+
+type Task struct {
+	Title string
+	Done  bool
+}
+
+func (t *Task) Item(r Request) *Item {
+	return NewItem(t)
+}
+
+func createTask(w ResponseWriter, r Request, input *TaskInput) (*Task, error) {
+	return &Task{Title: input.Title}, nil
+}
+
+func loadTask(w ResponseWriter, r Request) (*Task, error) {
+	return &Task{}, nil
+}
+
+type Profile struct {
+	Name    string `methods:"POST,PUT"`
+	IsAdmin bool
+}
+
+func (p *Profile) Item(r Request) *Item {
+	return NewItem(p)
+}
+
+func loadProfile(w ResponseWriter, r Request) (*Profile, error) {
+	return &Profile{}, nil
+}
+
+func updateProfile(w ResponseWriter, r Request, body *Profile) (*Profile, error) {
+	return body, nil
+}
+
+type Contact struct {
+	ID    string `access:"generated"`
+	Email string `access:"writeonce"`
+	Name  string `methods:"PUT"`
+}
+
+func (c *Contact) Item(r Request) *Item {
+	return NewItem(c)
+}
+
+func loadContact(w ResponseWriter, r Request) (*Contact, error) {
+	return &Contact{ID: r.Vars()["id"], Email: "a@b", Name: "n"}, nil
+}
+
+func updateContact(w ResponseWriter, r Request, body *Contact) (*Contact, error) {
+	contact, err := loadContact(w, r)
+	if err != nil {
+		return nil, err
+	}
+	contact.Name = body.Name
+	return contact, nil
+}
+
+type Secret struct {
+	Owner string
+}
+
+func (s *Secret) Item(r Request) *Item {
+	return NewItem(s)
+}
+
+func loadSecret(w ResponseWriter, r Request) (*Secret, error) {
+	principal, _ := PrincipalKey.Get(r)
+	return &Secret{Owner: principal.Scheme + ":" + principal.ID}, nil
+}
+
+func updateSecret(w ResponseWriter, r Request, body *Secret) (*Secret, error) {
+	return loadSecret(w, r)
+}
+
+func listSecrets(w ResponseWriter, r Request) error {
+	principal, _ := PrincipalKey.Get(r)
+	w.SetContent(NewItem(List{}).SetName(principal.ID))
+	return nil
+}
+
+type Doc struct {
+	ID    string
+	Owner string
+}
+
+var docLoads = 0
+
+var tracedHeader = http.Header{}
+
+type requestIDContextKey struct{}
+
+func (d *Doc) Item(r Request) *Item {
+	return NewItem(d).
+		AddLink(r.NewLink(docResource.Link("self", Load, []string{"id", d.ID}))).
+		AddLink(r.NewLink(docResource.Link("update", Update, []string{"id", d.ID})))
+}
+
+func loadDoc(w ResponseWriter, r Request) (*Doc, error) {
+	docLoads++
+	return &Doc{ID: r.Vars()["id"], Owner: "alice"}, nil
+}
+
+func updateDoc(w ResponseWriter, r Request) (*Doc, error) {
+	return loadDoc(w, r)
+}
+
+func createDoc(w ResponseWriter, r Request) (*Doc, error) {
+	return &Doc{ID: "new", Owner: "alice"}, nil
+}
+
+// isOwner allows principals owning the target Doc.
+func isOwner(r Request, target *Item) (bool, error) {
+	principal, found := PrincipalKey.Get(r)
+	return found && target != nil && target.Properties.(*Doc).Owner == principal.ID, nil
+}
+
+// isAuthenticated allows all principals.
+func isAuthenticated(r Request, target *Item) (bool, error) {
+	_, found := PrincipalKey.Get(r)
+	return found, nil
+}
+
 func init() {
 	userResource = &Resource{
 		Create:     createUser,
@@ -86,6 +291,91 @@ func init() {
 	}
 	router = mux.NewRouter()
 	HandleResource(router, userResource)
+	noteResource = &Resource{
+		Create: createNote,
+		Update: updateNote,
+		Load:   loadNote,
+	}
+	HandleResource(router, noteResource)
+	memoResource = NewResource(router, TypedResource[*Memo]{
+		CreateBody: createMemo,
+		Load:       loadMemo,
+		Listers: []Lister{
+			{
+				Path:    "/Memos",
+				Route:   "Memo.List",
+				Handler: listMemos,
+				RateLimit: &RateLimit{
+					Requests: 1,
+					Period:   time.Hour,
+					Key:      HeaderRateLimitKey("X-API-Key"),
+				},
+			},
+		},
+	})
+	taskResource = &Resource{
+		Create: createTask,
+		Load:   loadTask,
+		MaxBodySize: map[Method]int64{
+			Create: 64,
+		},
+	}
+	HandleResource(router, taskResource)
+	profileResource = NewResource(router, TypedResource[*Profile]{
+		Load:       loadProfile,
+		UpdateBody: updateProfile,
+		Strict:     true,
+	})
+	NewResource(router, TypedResource[*Contact]{
+		Load:       loadContact,
+		UpdateBody: updateContact,
+		Strict:     true,
+	})
+	secretResource = NewResource(router, TypedResource[*Secret]{
+		Load:       loadSecret,
+		UpdateBody: updateSecret,
+		Listers: []Lister{
+			{
+				Path:    "/Secrets",
+				Route:   "Secret.List",
+				Handler: listSecrets,
+				Auth:    AuthRequirement{Required: true, Schemes: []string{BasicScheme}},
+			},
+		},
+		Auth: map[Method]AuthRequirement{
+			Load:   {Required: true},
+			Update: {Required: true, Schemes: []string{BearerScheme}},
+		},
+	})
+	Handle(router, "/Panic", []string{"GET"}, "Panic", func(w ResponseWriter, r Request) error {
+		panic("boom")
+	})
+	AddFilter(func(w ResponseWriter, r Request) (bool, error) {
+		if id := r.Req().Header.Get("X-Request-ID"); id != "" {
+			r.SetContext(context.WithValue(r.Context(), requestIDContextKey{}, id))
+		}
+		return true, nil
+	})
+	Handle(router, "/RequestID", []string{"GET"}, "RequestID", func(w ResponseWriter, r Request) error {
+		id, _ := r.Context().Value(requestIDContextKey{}).(string)
+		w.SetContent(NewItem(List{}).SetName(id))
+		return nil
+	})
+	Handle(router, "/Traced", []string{"GET"}, "Traced", func(w ResponseWriter, r Request) error {
+		InjectTraceContext(r.Context(), tracedHeader)
+		w.SetContent(NewItem(List{}))
+		return nil
+	})
+	docResource = NewResource(router, TypedResource[*Doc]{
+		Create: createDoc,
+		Load:   loadDoc,
+		Update: updateDoc,
+		Policies: map[Method]Policy{
+			Create: isAuthenticated,
+			Load:   isAuthenticated,
+			Update: isOwner,
+		},
+	})
 }
 
 func TestToJava(t *testing.T) {
@@ -98,3 +388,734 @@ func TestToJava(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestFormSubmission(t *testing.T) {
+	req := httptest.NewRequest("POST", "/Note", strings.NewReader(url.Values{"Title": {"hello"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != 500 || !strings.Contains(rec.Body.String(), "unsupported Content-Type") {
+		t.Errorf("got %v %s for a form submission when disabled, want unsupported Content-Type", rec.Code, rec.Body.String())
+	}
+	req = httptest.NewRequest("POST", "/Note/1?"+MethodOverrideParam+"=PUT", strings.NewReader(`{"Title":"hello"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got %v %s for a method override when disabled, want %v", rec.Code, rec.Body.String(), http.StatusMethodNotAllowed)
+	}
+
+	SetCSRF(&CSRF{})
+	defer SetCSRF(nil)
+	SetFormSubmissions(true)
+	defer SetFormSubmissions(false)
+	token := &http.Cookie{Name: "_csrf", Value: "token"}
+
+	for _, tc := range []struct {
+		path       string
+		form       url.Values
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			path:       "/Note",
+			form:       url.Values{"Title": {"hello"}, "Count": {"2"}},
+			wantStatus: http.StatusSeeOther,
+		},
+		{
+			path:       "/Note",
+			form:       url.Values{"Title": {""}},
+			wantStatus: 422,
+			wantBody:   `<span class="error">required</span>`,
+		},
+		{
+			path:       "/Note",
+			form:       url.Values{"Title": {"a"}, "Count": {"x"}},
+			wantStatus: 422,
+			wantBody:   `<input type="number" step="1" name="Count" value="x"/>`,
+		},
+		{
+			path:       "/Note/1?" + MethodOverrideParam + "=PUT",
+			form:       url.Values{"Title": {"hello"}},
+			wantStatus: http.StatusSeeOther,
+		},
+	} {
+		tc.form.Set(CSRFField, token.Value)
+		req := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.wantStatus {
+			t.Errorf("%v %v: got status %v, want %v: %s", tc.path, tc.form, rec.Code, tc.wantStatus, rec.Body.String())
+		}
+		if tc.wantStatus == http.StatusSeeOther && !strings.HasSuffix(rec.Header().Get("Location"), "/Note/1") {
+			t.Errorf("%v %v: got Location %q, want it to point to /Note/1", tc.path, tc.form, rec.Header().Get("Location"))
+		}
+		if !strings.Contains(rec.Body.String(), tc.wantBody) {
+			t.Errorf("%v %v: got body %s, wanted it to contain %s", tc.path, tc.form, rec.Body.String(), tc.wantBody)
+		}
+	}
+}
+
+func TestContentSecurityPolicy(t *testing.T) {
+	req := httptest.NewRequest("GET", "/Note/1", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("got status %v: %s", rec.Code, rec.Body.String())
+	}
+	match := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(rec.Header().Get("Content-Security-Policy"))
+	if match == nil {
+		t.Fatalf("no nonce in Content-Security-Policy %q", rec.Header().Get("Content-Security-Policy"))
+	}
+	if !strings.Contains(rec.Header().Get("Content-Security-Policy"), "'unsafe-eval'") {
+		t.Errorf("Content-Security-Policy %q breaks the JSON forms", rec.Header().Get("Content-Security-Policy"))
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, fmt.Sprintf(`<script src="%s" nonce="%s">`, assetURL("browse.js"), match[1])) {
+		t.Errorf("browse.js not loaded with nonce %q in %s", match[1], body)
+	}
+	for _, script := range regexp.MustCompile(`<script[^>]*>`).FindAllString(body, -1) {
+		if !strings.Contains(script, "nonce=") && !strings.Contains(script, `type="application/json"`) {
+			t.Errorf("script %s without nonce in %s", script, body)
+		}
+	}
+	if strings.Contains(body, "<style") {
+		t.Errorf("inline style in %s", body)
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	countKey := NewKey[int]("count")
+	r := &request{values: map[string]interface{}{}}
+	if _, found := countKey.Get(r); found {
+		t.Errorf("found unset key")
+	}
+	countKey.Set(r, 3)
+	if count, found := countKey.Get(r); !found || count != 3 {
+		t.Errorf("got %v, %v, want 3, true", count, found)
+	}
+	if r.Values()["count"] != 3 {
+		t.Errorf("typed value not visible in Values()")
+	}
+	r.Values()["count"] = "three"
+	if _, found := countKey.Get(r); found {
+		t.Errorf("found value of wrong type")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/Note/1", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Body.Len() != 0 {
+		t.Errorf("got body %q for canceled request", rec.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/RequestID", nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Request-ID", "abc")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"Name":"abc"`) {
+		t.Errorf("got %v %s, want the request ID set by the filter", rec.Code, rec.Body.String())
+	}
+}
+
+func TestNewResource(t *testing.T) {
+	if memoResource.Type != reflect.TypeOf(Memo{}) {
+		t.Errorf("got type %v, want Memo", memoResource.Type)
+	}
+	if memoResource.Route(Load) != "Memo.Load" || router.Get("Memo.Load") == nil || router.Get("Memo.Create") == nil {
+		t.Errorf("routes not registered")
+	}
+	javaCode, err := memoResource.toJavaInterface("memo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`@POST("/Memo")
+  Observable<SingleContainer<Memo>> MemoCreate(@Body Memo memo);`,
+		`@GET("/Memo/{id}")
+  Observable<SingleContainer<Memo>> MemoLoad(@Path("id") String id);`,
+	} {
+		if !strings.Contains(javaCode, want) {
+			t.Errorf("got %s, wanted it to contain %s", javaCode, want)
+		}
+	}
+	req := httptest.NewRequest("GET", "/Memo/hello?accept=application/json", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"Text":"hello"`) {
+		t.Errorf("got %v %s, want 200 with the memo", rec.Code, rec.Body.String())
+	}
+	req = httptest.NewRequest("POST", "/Memo?accept=application/json", strings.NewReader(`{"Text":"created"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"Text":"created"`) {
+		t.Errorf("got %v %s, want 200 with the decoded memo", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBodyHandler(t *testing.T) {
+	if typ := taskResource.Link("create", Create, nil).Type; typ != reflect.TypeOf(TaskInput{}) {
+		t.Errorf("got link type %v, want TaskInput", typ)
+	}
+	if typ := taskResource.Link("self", Load, nil).Type; typ != reflect.TypeOf(Task{}) {
+		t.Errorf("got link type %v, want Task", typ)
+	}
+	javaCode, err := taskResource.toJavaInterface("task")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "TaskCreate(@Body TaskInput taskinput)"; !strings.Contains(javaCode, want) {
+		t.Errorf("got %s, wanted it to contain %s", javaCode, want)
+	}
+	classes, err := taskResource.toJavaClasses("task", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := classes["TaskInput"]; !found {
+		t.Errorf("no TaskInput class generated")
+	}
+	for _, tc := range []struct {
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{body: `{"Title":"x","Done":true}`, wantStatus: 200, wantBody: `"Title":"x","Done":false`},
+		{body: `{}`, wantStatus: 422, wantBody: "required"},
+		{body: `{`, wantStatus: 400},
+		{body: `{"Title":"` + strings.Repeat("x", 64) + `"}`, wantStatus: 413},
+	} {
+		req := httptest.NewRequest("POST", "/Task?accept=application/json", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.wantStatus || !strings.Contains(rec.Body.String(), tc.wantBody) {
+			t.Errorf("%s: got %v %s, want %v with %s", tc.body, rec.Code, rec.Body.String(), tc.wantStatus, tc.wantBody)
+		}
+	}
+}
+
+func TestStrictCopy(t *testing.T) {
+	SetCSRF(&CSRF{})
+	defer SetCSRF(nil)
+	SetFormSubmissions(true)
+	defer SetFormSubmissions(false)
+	token := &http.Cookie{Name: "_csrf", Value: "token"}
+
+	for _, tc := range []struct {
+		contentType string
+		body        string
+		wantStatus  int
+		wantBody    string
+	}{
+		{
+			contentType: "application/json",
+			body:        `{"Name":"x"}`,
+			wantStatus:  200,
+			wantBody:    `"Name":"x"`,
+		},
+		{
+			contentType: "application/json",
+			body:        `{"Name":"x","IsAdmin":true,"Level":9}`,
+			wantStatus:  422,
+			wantBody:    "rejected fields: IsAdmin (not writable using PUT), Level (unknown)",
+		},
+		{
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"Name": {"x"}, "IsAdmin": {"true"}}.Encode(),
+			wantStatus:  422,
+			wantBody:    "rejected fields: IsAdmin (not writable using PUT)",
+		},
+	} {
+		req := httptest.NewRequest("PUT", "/Profile/1", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("X-CSRF-Token", token.Value)
+		req.AddCookie(token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.wantStatus || !strings.Contains(rec.Body.String(), tc.wantBody) {
+			t.Errorf("%s: got %v %s, want %v with %s", tc.body, rec.Code, rec.Body.String(), tc.wantStatus, tc.wantBody)
+		}
+	}
+}
+
+func TestUpdateBodyRoundTrip(t *testing.T) {
+	req := httptest.NewRequest("GET", "/Contact/1", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	loaded := struct {
+		Properties map[string]interface{}
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &loaded); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body.String())
+	}
+	loaded.Properties["Name"] = "m"
+	body, err := json.Marshal(loaded.Properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest("PUT", "/Contact/1", bytes.NewReader(body))
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"Email":"a@b","Name":"m"`) {
+		t.Errorf("got %v %s for %s, want the updated Contact", rec.Code, rec.Body.String(), body)
+	}
+}
+
+func TestInvalidRateLimit(t *testing.T) {
+	for _, limit := range []*RateLimit{
+		{Period: time.Minute},
+		{Requests: 1},
+		{Requests: 1, Period: -time.Minute},
+		{Requests: 1, Period: time.Minute, Burst: -1},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("got no panic for %+v", limit)
+				}
+			}()
+			SetRateLimit(limit)
+		}()
+	}
+	if rateLimit != nil {
+		t.Errorf("got %+v, want the invalid limits ignored", rateLimit)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	SetRateLimitStore(NewMemoryRateLimitStore())
+	SetRateLimit(&RateLimit{
+		Requests: 2,
+		Period:   time.Minute,
+	})
+	defer SetRateLimit(nil)
+	defer SetTrustedProxies(0)
+
+	for _, tc := range []struct {
+		path           string
+		remoteAddr     string
+		forwardedFor   string
+		trustedProxies int
+		apiKey         string
+		wantStatus     int
+		wantRemaining  string
+		wantRetryAfter string
+	}{
+		{
+			path:          "/Memo/a",
+			remoteAddr:    "10.0.0.1:1234",
+			wantStatus:    200,
+			wantRemaining: "1",
+		},
+		{
+			path:          "/Memo/b",
+			remoteAddr:    "10.0.0.1:1235",
+			wantStatus:    200,
+			wantRemaining: "0",
+		},
+		{
+			path:           "/Memo/a",
+			remoteAddr:     "10.0.0.1:1236",
+			wantStatus:     429,
+			wantRemaining:  "0",
+			wantRetryAfter: "30",
+		},
+		{
+			path:           "/Memo/a",
+			remoteAddr:     "10.0.0.1:1237",
+			forwardedFor:   "10.0.0.2",
+			wantStatus:     429,
+			wantRemaining:  "0",
+			wantRetryAfter: "30",
+		},
+		{
+			path:           "/Memo/a",
+			remoteAddr:     "10.0.0.1:1237",
+			forwardedFor:   "10.0.0.2",
+			trustedProxies: 1,
+			wantStatus:     200,
+			wantRemaining:  "1",
+		},
+		{
+			path:           "/Memo/a",
+			remoteAddr:     "10.0.0.1:1237",
+			forwardedFor:   "10.0.0.9, 10.0.0.2",
+			trustedProxies: 1,
+			wantStatus:     200,
+			wantRemaining:  "0",
+		},
+		{
+			path:           "/Memo/a",
+			remoteAddr:     "10.0.0.1:1237",
+			forwardedFor:   "10.0.0.2, 10.0.0.5, 10.0.0.4",
+			trustedProxies: 2,
+			wantStatus:     200,
+			wantRemaining:  "1",
+		},
+		{
+			path:          "/Memos",
+			remoteAddr:    "10.0.0.1:1238",
+			apiKey:        "k1",
+			wantStatus:    200,
+			wantRemaining: "0",
+		},
+		{
+			path:           "/Memos",
+			remoteAddr:     "10.0.0.3:1239",
+			apiKey:         "k1",
+			wantStatus:     429,
+			wantRemaining:  "0",
+			wantRetryAfter: "3600",
+		},
+		{
+			path:          "/Memos",
+			remoteAddr:    "10.0.0.1:1240",
+			apiKey:        "k2",
+			wantStatus:    200,
+			wantRemaining: "0",
+		},
+	} {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set("Accept", "application/json")
+		req.RemoteAddr = tc.remoteAddr
+		SetTrustedProxies(tc.trustedProxies)
+		if tc.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		if tc.apiKey != "" {
+			req.Header.Set("X-API-Key", tc.apiKey)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.wantStatus {
+			t.Errorf("%s from %s: got %v %s, want %v", tc.path, tc.remoteAddr, rec.Code, rec.Body.String(), tc.wantStatus)
+		}
+		if got := rec.Header().Get("RateLimit-Remaining"); got != tc.wantRemaining {
+			t.Errorf("%s from %s: got RateLimit-Remaining %q, want %q", tc.path, tc.remoteAddr, got, tc.wantRemaining)
+		}
+		if got := rec.Header().Get("Retry-After"); got != tc.wantRetryAfter {
+			t.Errorf("%s from %s: got Retry-After %q, want %q", tc.path, tc.remoteAddr, got, tc.wantRetryAfter)
+		}
+	}
+}
+
+type recordingReporter struct {
+	reports []*PanicReport
+}
+
+func (r *recordingReporter) ReportPanic(report *PanicReport) {
+	r.reports = append(r.reports, report)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	reporter := &recordingReporter{}
+	SetErrorReporter(reporter)
+	defer SetErrorReporter(logErrorReporter{})
+
+	for _, tc := range []struct {
+		accept   string
+		wantBody string
+	}{
+		{
+			accept:   "application/json",
+			wantBody: "\"internal error\"\n",
+		},
+		{
+			accept:   "text/html",
+			wantBody: "internal error\n",
+		},
+	} {
+		req := httptest.NewRequest("GET", "/Panic", nil)
+		req.Header.Set("Accept", tc.accept)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != 500 || rec.Body.String() != tc.wantBody {
+			t.Errorf("%s: got %v %q, want 500 %q", tc.accept, rec.Code, rec.Body.String(), tc.wantBody)
+		}
+	}
+	if len(reporter.reports) != 2 {
+		t.Fatalf("got %v reports, want 2", len(reporter.reports))
+	}
+	report := reporter.reports[0]
+	if report.Value != "boom" || report.Route != "Panic" || report.Request.URL.Path != "/Panic" || !strings.Contains(string(report.Stack), "resource_test.go") {
+		t.Errorf("got %v, want a report of the panic", report)
+	}
+}
+
+type logEntry struct {
+	level     LogLevel
+	msg       string
+	attrs     map[string]interface{}
+	requestID interface{}
+}
+
+type recordingLogger struct {
+	entries []logEntry
+}
+
+func (l *recordingLogger) Log(ctx context.Context, level LogLevel, msg string, attrs ...interface{}) {
+	entry := logEntry{level: level, msg: msg, attrs: map[string]interface{}{}, requestID: ctx.Value(requestIDContextKey{})}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		entry.attrs[attrs[i].(string)] = attrs[i+1]
+	}
+	l.entries = append(l.entries, entry)
+}
+
+func TestLogging(t *testing.T) {
+	logger := &recordingLogger{}
+	SetLogger(logger)
+	defer SetLogger(StdLogger{})
+
+	req := httptest.NewRequest("GET", "/Memo/hello", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if len(logger.entries) != 1 {
+		t.Fatalf("got %+v, want one access log entry", logger.entries)
+	}
+	entry := logger.entries[0]
+	if entry.level != LogInfo || entry.msg != "request" ||
+		entry.attrs["method"] != "GET" ||
+		entry.attrs["url"] != "/Memo/hello" ||
+		entry.attrs["route"] != "Memo.Load" ||
+		entry.attrs["media"] != "application/json" ||
+		entry.attrs["status"] != 200 ||
+		entry.attrs["bytes"] != int64(rec.Body.Len()) ||
+		entry.attrs["principal"] != "" {
+		t.Errorf("got %+v, want access log of the request", entry)
+	}
+	if _, ok := entry.attrs["latency"].(time.Duration); !ok {
+		t.Errorf("got latency %v, want a duration", entry.attrs["latency"])
+	}
+
+	logger.entries = nil
+	SetErrorLogLevel(func(status int) LogLevel {
+		return LogWarn
+	})
+	defer SetErrorLogLevel(DefaultErrorLogLevel)
+	SetLogLevel(LogWarn)
+	defer SetLogLevel(LogInfo)
+	req = httptest.NewRequest("POST", "/Task", strings.NewReader(`{}`))
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", "r1")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if len(logger.entries) != 1 || logger.entries[0].level != LogWarn || logger.entries[0].msg != "error response" || logger.entries[0].attrs["status"] != 422 || logger.entries[0].requestID != "r1" {
+		t.Errorf("got %+v, want only the error response logged with the request context", logger.entries)
+	}
+
+	buf := &bytes.Buffer{}
+	SetLogger(SlogLogger(slog.New(slog.NewTextHandler(buf, nil))))
+	SetLogLevel(LogInfo)
+	req = httptest.NewRequest("GET", "/Memo/hello", nil)
+	req.Header.Set("Accept", "application/json")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if !strings.Contains(buf.String(), "level=INFO msg=request method=GET url=/Memo/hello route=Memo.Load media=application/json status=200") {
+		t.Errorf("got %q, want access log via slog", buf.String())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewPrometheusMetrics()
+	SetMetrics(m)
+	defer SetMetrics(nil)
+	SetErrorReporter(&recordingReporter{})
+	defer SetErrorReporter(logErrorReporter{})
+
+	bodySize := 0
+	for _, tc := range []struct {
+		method string
+		path   string
+		accept string
+	}{
+		{"GET", "/Memo/a", "application/json"},
+		{"GET", "/Memo/a", "application/json"},
+		{"POST", "/Task", "application/json"},
+		{"GET", "/Panic", "text/html"},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader("{}"))
+		req.Header.Set("Accept", tc.accept)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if tc.path == "/Memo/a" {
+			bodySize += rec.Body.Len()
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("got Content-Type %q, want Prometheus text format", ct)
+	}
+	memoLabels := `route="Memo.Load",method="GET",status="2xx",media="application/json"`
+	for _, want := range []string{
+		"# TYPE goaeoas_requests_in_flight gauge\n",
+		`goaeoas_requests_in_flight{route="Memo.Load",method="GET"} 0` + "\n",
+		"# TYPE goaeoas_requests_total counter\n",
+		`goaeoas_requests_total{` + memoLabels + `} 2` + "\n",
+		`goaeoas_requests_total{route="Task.Create",method="POST",status="4xx",media="application/json"} 1` + "\n",
+		`goaeoas_requests_total{route="Panic",method="GET",status="5xx",media="text/html"} 1` + "\n",
+		"# TYPE goaeoas_request_duration_seconds histogram\n",
+		`goaeoas_request_duration_seconds_bucket{` + memoLabels + `,le="10"} 2` + "\n",
+		`goaeoas_request_duration_seconds_bucket{` + memoLabels + `,le="+Inf"} 2` + "\n",
+		`goaeoas_request_duration_seconds_count{` + memoLabels + `} 2` + "\n",
+		`goaeoas_response_size_bytes_bucket{` + memoLabels + `,le="100"} 2` + "\n",
+		fmt.Sprintf("goaeoas_response_size_bytes_sum{%s} %d\n", memoLabels, bodySize),
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("got\n%s\nwant %q", rec.Body.String(), want)
+		}
+	}
+	if got := promLabels("route", "a\"b\\c\nd"); got != `route="a\"b\\c\nd"` {
+		t.Errorf("got %s, want escaped label", got)
+	}
+}
+
+type recordedSpan struct {
+	name   string
+	parent *recordedSpan
+	sc     SpanContext
+	attrs  map[string]interface{}
+	err    error
+	ended  bool
+}
+
+func (s *recordedSpan) SpanContext() SpanContext {
+	return s.sc
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...interface{}) {
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.attrs[attrs[i].(string)] = attrs[i+1]
+	}
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.err = err
+}
+
+func (s *recordedSpan) End() {
+	s.ended = true
+}
+
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &recordedSpan{name: name, attrs: map[string]interface{}{}}
+	span.sc.SpanID[0] = byte(len(t.spans) + 1)
+	if parent, ok := SpanFromContext(ctx).(*recordedSpan); ok {
+		span.parent = parent
+		span.sc.TraceID = parent.sc.TraceID
+		span.sc.TraceState = parent.sc.TraceState
+	} else if remote := RemoteSpanContext(ctx); remote.IsValid() {
+		span.sc.TraceID = remote.TraceID
+		span.sc.TraceState = remote.TraceState
+	} else {
+		span.sc.TraceID[0] = 1
+	}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func TestTracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	req := httptest.NewRequest("GET", "/Traced", nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	req.Header.Set("tracestate", "vendor=value")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("got %v %s, want 200", rec.Code, rec.Body.String())
+	}
+	names := []string{}
+	for _, span := range tracer.spans {
+		parent := ""
+		if span.parent != nil {
+			parent = span.parent.name
+		}
+		names = append(names, fmt.Sprintf("%s<%s", span.name, parent))
+		if !span.ended {
+			t.Errorf("span %s not ended", span.name)
+		}
+		if got := hex.EncodeToString(span.sc.TraceID[:]); got != "0af7651916cd43dd8448eb211c80319c" {
+			t.Errorf("span %s got trace ID %s, want the one of the traceparent", span.name, got)
+		}
+	}
+	if got := strings.Join(names, ", "); got != "Traced<, filters<Traced, handler<Traced, render<Traced" {
+		t.Errorf("got spans %s", got)
+	}
+	root := tracer.spans[0]
+	if root.attrs["http.route"] != "Traced" || root.attrs["http.request.method"] != "GET" || root.attrs["http.response.status_code"] != 200 || root.err != nil {
+		t.Errorf("got root span attributes %+v, error %v", root.attrs, root.err)
+	}
+	if got := tracedHeader.Get("traceparent"); got != "00-0af7651916cd43dd8448eb211c80319c-0300000000000000-00" {
+		t.Errorf("got propagated traceparent %q, want the one of the handler span", got)
+	}
+	if got := tracedHeader.Get("tracestate"); got != "vendor=value" {
+		t.Errorf("got propagated tracestate %q, want vendor=value", got)
+	}
+
+	// Contexts set by filters outlive the filters span, which isn't the parent of later spans.
+	tracer.spans = nil
+	req = httptest.NewRequest("GET", "/RequestID", nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Request-ID", "abc")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"Name":"abc"`) {
+		t.Errorf("got %v %s, want the request ID set by the filter", rec.Code, rec.Body.String())
+	}
+	for _, span := range tracer.spans[1:] {
+		if span.parent != tracer.spans[0] {
+			t.Errorf("got span %s with parent %+v, want the root span", span.name, span.parent)
+		}
+	}
+
+	// The span of a panicking handler is ended with the panic.
+	SetErrorReporter(&recordingReporter{})
+	defer SetErrorReporter(logErrorReporter{})
+	tracer.spans = nil
+	req = httptest.NewRequest("GET", "/Panic", nil)
+	req.Header.Set("Accept", "application/json")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if len(tracer.spans) != 3 || tracer.spans[2].name != "handler" || !tracer.spans[2].ended || tracer.spans[2].err == nil || !strings.Contains(tracer.spans[2].err.Error(), "boom") {
+		t.Errorf("got spans %+v, want an ended handler span with the panic", tracer.spans)
+	}
+	if root := tracer.spans[0]; !root.ended || root.attrs["http.response.status_code"] != 500 {
+		t.Errorf("got root span %+v, want it ended with status 500", root)
+	}
+
+	for _, tc := range []struct {
+		traceParent string
+		valid       bool
+	}{
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", true},
+		{"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00-future", true},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra", false},
+		{"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", false},
+		{"00-00000000000000000000000000000000-b7ad6b7169203331-01", false},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b71692033-01", false},
+		{"00-0af7651916cd43dd8448eb211c80319x-b7ad6b7169203331-01", false},
+	} {
+		if _, valid := ParseTraceParent(tc.traceParent, ""); valid != tc.valid {
+			t.Errorf("%s: got valid %v, want %v", tc.traceParent, valid, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package goaeoas
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	theme Theme
+)
+
+func init() {
+	theme = DefaultTheme()
+}
+
+// Theme renders the browsable HTML version of the API.
+// Each hook can be replaced separately, any hook left nil
+// when calling SetTheme falls back to the DefaultTheme.
+type Theme struct {
+	// Head populates the head element of each HTML response, after
+	// the HeadCallbacks have run.
+	Head func(r Request, head *Node) error
+	// Layout builds the complete document from the populated head and
+	// the rendered content of the response.
+	Layout func(r Request, head, content *Node) (*Node, error)
+	// Item renders an Item.
+	Item func(v *ItemView) (*Node, error)
+	// Properties renders the Properties of an Item when they are not a List.
+	Properties func(v *ItemView) (*Node, error)
+	// List renders the Properties of an Item when they are a List.
+	List func(v *ListView) (*Node, error)
+	// Link renders a link of an Item.
+	Link func(v *LinkView) (*Node, error)
+}
+
+// DefaultTheme returns the built in theme.
+func DefaultTheme() Theme {
+	return Theme{
+		Head:       defaultHead,
+		Layout:     defaultLayout,
+		Item:       defaultItem,
+		Properties: defaultProperties,
+		List:       defaultList,
+		Link:       defaultLink,
+	}
+}
+
+// SetTheme replaces the theme used to render HTML responses.
+func SetTheme(t Theme) {
+	def := DefaultTheme()
+	if t.Head == nil {
+		t.Head = def.Head
+	}
+	if t.Layout == nil {
+		t.Layout = def.Layout
+	}
+	if t.Item == nil {
+		t.Item = def.Item
+	}
+	if t.Properties == nil {
+		t.Properties = def.Properties
+	}
+	if t.List == nil {
+		t.List = def.List
+	}
+	if t.Link == nil {
+		t.Link = def.Link
+	}
+	theme = t
+}
+
+// ItemView is what the theme gets when rendering an Item.
+type ItemView struct {
+	Item *Item
+	// SelfURL is the resolved URL of the "self" link of the Item, if any.
+	SelfURL string
+	// Links are the remaining links of the Item, sorted.
+	Links []*LinkView
+	// List is the Properties of the Item if they are a List.
+	List List
+}
+
+// RenderLinks renders the Links of the view using the current theme.
+func (v *ItemView) RenderLinks() ([]*Node, error) {
+	result := make([]*Node, 0, len(v.Links))
+	for _, link := range v.Links {
+		linkNode, err := theme.Link(link)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, linkNode)
+	}
+	return result, nil
+}
+
+// RenderList renders the List of the view using the current theme.
+func (v *ItemView) RenderList() (*Node, error) {
+	listView := &ListView{
+		Item: v.Item,
+		List: v.List,
+	}
+	for _, content := range v.List {
+		contentNode, err := content.HTMLNode()
+		if err != nil {
+			return nil, err
+		}
+		listView.Elements = append(listView.Elements, contentNode)
+	}
+	return theme.List(listView)
+}
+
+// ListView is what the theme gets when rendering a List.
+type ListView struct {
+	Item *Item
+	List List
+	// Elements are the rendered elements of the List.
+	Elements []*Node
+}
+
+// LinkView is what the theme gets when rendering a Link.
+type LinkView struct {
+	Link *Link
+	// URL is the resolved URL of the Link.
+	URL string
+	// Method is the HTTP method of the Link, never empty.
+	Method string
+	// DocType and Schema describe the body of the Link, and are
+	// only set for POST and PUT links with a Type. Schema has no references.
+	DocType *DocType
+	Schema  *JSONSchema
+	// Values prefill the native form of the Link.
+	Values url.Values
+	// FieldErrors are shown next to the fields of the native form of the Link.
+	FieldErrors map[string]string
+	// CSRFToken must be sent by forms of the Link, see SetCSRF.
+	CSRFToken string
+}
+
+func newLinkView(l *Link) (*LinkView, error) {
+	u, err := l.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	result := &LinkView{
+		Link:   l,
+		URL:    u,
+		Method: l.Method,
+	}
+	if result.Method == "" {
+		result.Method = "GET"
+	}
+	if l.req != nil {
+		result.CSRFToken = CSRFToken(l.req)
+	}
+	if (result.Method == "POST" || result.Method == "PUT") && l.Type != nil {
+		if result.DocType, err = NewDocType(l.Type, result.Method); err != nil {
+			return nil, err
+		}
+		if result.Schema, err = result.DocType.ToInlineJSONSchema(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// TemplateNode executes t with data and parses the result as an HTML fragment.
+// If the fragment has a single root element it is returned, otherwise the
+// fragment is wrapped in a div.
+func TemplateNode(t *template.Template, data interface{}) (*Node, error) {
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	nodes, err := html.ParseFragment(buf, &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	elements := 0
+	for _, node := range nodes {
+		if node.Type == html.ElementNode {
+			elements++
+		}
+	}
+	if elements == 1 && len(nodes) == 1 {
+		return &Node{nodes[0]}, nil
+	}
+	result := NewEl("div")
+	for _, node := range nodes {
+		result.AppendChild(node)
+	}
+	return result, nil
+}
+
+// JSONScriptNode returns a script element of type application/json with id, containing v encoded as JSON.
+// The encoding escapes <, > and & so the content can't terminate the element, and scripts
+// read it using JSON.parse(document.getElementById(id).textContent).
+func JSONScriptNode(id string, v interface{}) (*Node, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	scriptNode := NewEl("script", "type", "application/json", "id", id)
+	scriptNode.AddText(buf.String())
+	return scriptNode, nil
+}
+
+// safeURL returns u if it's relative or uses a scheme that can't run scripts, and "#" otherwise.
+func safeURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "#"
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "", "http", "https", "mailto":
+		return u
+	}
+	return "#"
+}
+
+func defaultHead(r Request, headNode *Node) error {
+	nonce := r.Nonce()
+	headNode.AddEl("script", "src", "https://ajax.googleapis.com/ajax/libs/jquery/3.1.1/jquery.min.js", "nonce", nonce)
+	headNode.AddEl("script", "src", "https://cdnjs.cloudflare.com/ajax/libs/underscore.js/1.6.0/underscore-min.js", "nonce", nonce)
+	if jsonFormURL != nil {
+		headNode.AddEl("script", "src", jsonFormURL.String(), "nonce", nonce)
+	} else {
+		headNode.AddEl("script", "src", assetURL("jsonform.js"), "nonce", nonce)
+	}
+	if jsvURL != nil {
+		headNode.AddEl("script", "src", jsvURL.String(), "nonce", nonce)
+	} else {
+		headNode.AddEl("script", "src", assetURL("jsv.js"), "nonce", nonce)
+	}
+	headNode.AddEl("script", "src", assetURL("browse.js"), "nonce", nonce)
+	headNode.AddEl("link", "rel", "stylesheet", "href", assetURL("browse.css"))
+	return nil
+}
+
+func defaultLayout(r Request, headNode, contentNode *Node) (*Node, error) {
+	htmlNode := NewEl("html")
+	htmlNode.AddNode(headNode)
+	htmlNode.AddEl("body").AddNode(contentNode)
+	return htmlNode, nil
+}
+
+func defaultItem(v *ItemView) (*Node, error) {
+	i := v.Item
+	itemNode := NewEl("section")
+	titleNode := itemNode.AddEl("header")
+	if v.SelfURL == "" {
+		titleNode.AddText(i.Name)
+	} else {
+		titleNode.AddEl("a", "href", safeURL(v.SelfURL)).AddText(i.Name)
+	}
+	if len(i.Desc) > 0 {
+		descNode := itemNode.AddEl("section")
+		descNode.AddEl("header").AddText("Description")
+		for _, part := range i.Desc {
+			if len(part) > 0 {
+				articleNode := descNode.AddEl("article")
+				articleNode.AddEl("header").AddText(part[0])
+				for _, paragraph := range part[1:] {
+					articleNode.AddEl("p").AddText(paragraph)
+				}
+			}
+		}
+	}
+	propNode := itemNode.AddEl("section")
+	propNode.AddEl("header").AddText("Properties")
+	if v.List != nil {
+		listNode, err := v.RenderList()
+		if err != nil {
+			return nil, err
+		}
+		propNode.AddNode(listNode)
+	} else {
+		propertiesNode, err := theme.Properties(v)
+		if err != nil {
+			return nil, err
+		}
+		propNode.AddEl("article").AddNode(propertiesNode)
+	}
+	if len(v.Links) > 0 {
+		navNode := itemNode.AddEl("nav")
+		linkNodes, err := v.RenderLinks()
+		if err != nil {
+			return nil, err
+		}
+		for _, linkNode := range linkNodes {
+			navNode.AddNode(linkNode)
+		}
+	}
+	return itemNode, nil
+}
+
+func defaultProperties(v *ItemView) (*Node, error) {
+	return PropertiesNode(v.Item.Properties)
+}
+
+func defaultList(v *ListView) (*Node, error) {
+	listNode := NewEl("ul")
+	for _, element := range v.Elements {
+		listNode.AddEl("ul").AddNode(element)
+	}
+	return listNode, nil
+}
+
+func defaultLink(v *LinkView) (*Node, error) {
+	l := v.Link
+	if v.Method == "GET" {
+		linkNode := NewEl("a", "href", safeURL(v.URL))
+		linkNode.AddText(l.Rel)
+		return linkNode, nil
+	}
+	if (v.Method == "POST" || v.Method == "PUT") && v.DocType != nil && len(v.DocType.Fields) > 0 && v.FieldErrors == nil {
+		schemaID := fmt.Sprintf("schema%d", atomic.AddUint64(&nextElementID, 1))
+		schemaNode, err := JSONScriptNode(schemaID, v.Schema)
+		if err != nil {
+			return nil, err
+		}
+		attrs := []string{
+			"data-goaeoas-form", "",
+			"data-schema", schemaID,
+			"data-rel", l.Rel,
+			"data-method", v.Method,
+			"data-url", safeURL(v.URL),
+			"data-render", fmt.Sprint(l.Render),
+		}
+		if v.CSRFToken != "" {
+			attrs = append(attrs, "data-csrf-header", csrf.HeaderName, "data-csrf-token", v.CSRFToken)
+		}
+		linkNode := NewEl("div", attrs...)
+		linkNode.AddNode(schemaNode)
+		if formSubmissions {
+			linkNode.AddNode(FormNode(v))
+		}
+		return linkNode, nil
+	}
+	if formSubmissions {
+		linkNode := NewEl("div")
+		linkNode.AddNode(FormNode(v))
+		return linkNode, nil
+	}
+	attrs := []string{
+		"data-goaeoas-button", "",
+		"data-method", v.Method,
+		"data-url", safeURL(v.URL),
+		"data-render", fmt.Sprint(l.Render),
+	}
+	if v.CSRFToken != "" {
+		attrs = append(attrs, "data-csrf-header", csrf.HeaderName, "data-csrf-token", v.CSRFToken)
+	}
+	linkNode := NewEl("div", attrs...)
+	linkNode.AddEl("button").AddText(l.Rel)
+	return linkNode, nil
+}
@@ -0,0 +1,180 @@
+package goaeoas
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestSetTheme(t *testing.T) {
+	defer SetTheme(DefaultTheme())
+	tmpl := template.Must(template.New("item").Parse(`<article class="custom">{{.Item.Name}}</article>`))
+	SetTheme(Theme{
+		Item: func(v *ItemView) (*Node, error) {
+			return TemplateNode(tmpl, v)
+		},
+	})
+	node, err := NewItem(&User{}).SetName("<b>name</b>").HTMLNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := node.Render(buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := `<article class="custom">&lt;b&gt;name&lt;/b&gt;</article>`; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+	if theme.Link == nil || theme.Head == nil || theme.Layout == nil || theme.List == nil {
+		t.Errorf("SetTheme didn't fall back to the default hooks")
+	}
+	if strings.Contains(buf.String(), "<pre>") {
+		t.Errorf("default item rendering used despite override")
+	}
+}
+
+func TestPropertiesNode(t *testing.T) {
+	node, err := PropertiesNode(&User{
+		Name: "<script>",
+		Addresses: []Address{
+			{
+				City: "https://example.com/city",
+				Images: map[string]Image{
+					"b": {Mime: "image/png"},
+					"a": {Mime: "image/jpeg"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := node.Render(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<table class="properties"><tbody><tr><th>Name</th><td><span>&lt;script&gt;</span></td></tr>`,
+		`<a href="https://example.com/city">https://example.com/city</a>`,
+		`<dl><dt>a</dt><dd><table class="properties"><tbody><tr><th>Mime</th><td><span>image/jpeg</span>`,
+		`<th>IsAdmin</th><td><span>false</span></td>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("got %q, wanted it to contain %q", buf.String(), want)
+		}
+	}
+}
+
+type Checksum struct {
+	Digest []byte
+	Prefix [2]byte
+}
+
+func TestPropertiesNodeBytes(t *testing.T) {
+	node, err := PropertiesNode(Checksum{Digest: []byte{1, 2}, Prefix: [2]byte{3, 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := node.Render(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<th>Digest</th><td><code class="bytes">AQI=</code></td>`,
+		`<th>Prefix</th><td><ul><li><span>3</span></li><li><span>4</span></li></ul></td>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("got %q, wanted it to contain %q", buf.String(), want)
+		}
+	}
+}
+
+type Hostile struct {
+	Text string `methods:"POST,PUT"`
+}
+
+func TestHostileHTML(t *testing.T) {
+	SetCSRF(&CSRF{})
+	defer SetCSRF(nil)
+	defer SetFormSubmissions(false)
+	hostile := `</script><script>alert(1)</script>"'<img src=x onerror=alert(1)>`
+	for _, native := range []bool{false, true} {
+		SetFormSubmissions(native)
+		for _, link := range []Link{
+			{Rel: hostile, URL: "/hostile", Method: "POST", Type: reflect.TypeOf(Hostile{}), Render: true},
+			{Rel: hostile, URL: "javascript:alert(1)"},
+			{Rel: hostile, URL: " javascript:alert(1)", Method: "DELETE"},
+			{Rel: hostile, URL: "/hostile?a=" + hostile, Method: "PUT", Type: reflect.TypeOf(Hostile{})},
+			{},
+		} {
+			var content Content = NewItem(&Hostile{Text: hostile}).
+				SetName(hostile).
+				SetDesc([][]string{{hostile, hostile}}).
+				AddLink(Link{Rel: "self", URL: "javascript:alert(1)"}).
+				AddLink(link)
+			if link.Rel == "" {
+				// The page shown after form submissions links back to the Referer.
+				content = formDone{back: "javascript:alert(1)"}
+			}
+			node, err := content.HTMLNode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := &bytes.Buffer{}
+			if err := node.Render(buf); err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := html.Parse(bytes.NewBufferString(buf.String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			forms := 0
+			var walk func(*html.Node)
+			walk = func(n *html.Node) {
+				if n.Type == html.ElementNode {
+					attrs := map[string]string{}
+					for _, attr := range n.Attr {
+						attrs[attr.Key] = attr.Val
+						if strings.HasPrefix(attr.Key, "on") {
+							t.Errorf("event handler attribute %q on %v in %s", attr.Key, n.Data, buf.String())
+						}
+						if (attr.Key == "href" || attr.Key == "action" || attr.Key == "data-url") && strings.Contains(attr.Val, "javascript:") {
+							t.Errorf("script URL %q in %s", attr.Val, buf.String())
+						}
+					}
+					switch n.Data {
+					case "img":
+						t.Errorf("injected img element in %s", buf.String())
+					case "script":
+						if attrs["type"] != "application/json" {
+							t.Errorf("executable script in %s", buf.String())
+						}
+						schema := map[string]interface{}{}
+						if err := json.Unmarshal([]byte(n.FirstChild.Data), &schema); err != nil {
+							t.Errorf("unparseable JSON script %q: %v", n.FirstChild.Data, err)
+						}
+					case "div":
+						if _, found := attrs["data-goaeoas-form"]; found {
+							forms++
+							if attrs["data-rel"] != hostile {
+								t.Errorf("got data-rel %q, want %q", attrs["data-rel"], hostile)
+							}
+						}
+					}
+				}
+				for child := n.FirstChild; child != nil; child = child.NextSibling {
+					walk(child)
+				}
+			}
+			walk(parsed)
+			if link.Type != nil && forms != 1 {
+				t.Errorf("got %v JSON forms for %+v, want 1", forms, link)
+			}
+		}
+	}
+}
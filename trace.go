@@ -0,0 +1,204 @@
+package goaeoas
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	tracer Tracer
+)
+
+type spanContextKey struct{}
+
+type remoteSpanContextKey struct{}
+
+// SpanContext identifies a span across processes, as described by https://www.w3.org/TR/trace-context/.
+type SpanContext struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid returns whether s has non zero IDs.
+func (s SpanContext) IsValid() bool {
+	return s.TraceID != [16]byte{} && s.SpanID != [8]byte{}
+}
+
+// TraceParent returns the traceparent header value identifying s.
+func (s SpanContext) TraceParent() string {
+	flags := 0
+	if s.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", hex.EncodeToString(s.TraceID[:]), hex.EncodeToString(s.SpanID[:]), flags)
+}
+
+// ParseTraceParent parses the traceparent and tracestate header values of a remote span.
+func ParseTraceParent(traceParent, traceState string) (SpanContext, bool) {
+	result := SpanContext{}
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return result, false
+	}
+	// Later versions may add fields after these.
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return result, false
+	}
+	if _, err := hex.DecodeString(parts[0]); err != nil {
+		return result, false
+	}
+	if _, err := hex.Decode(result.TraceID[:], []byte(parts[1])); err != nil {
+		return result, false
+	}
+	if _, err := hex.Decode(result.SpanID[:], []byte(parts[2])); err != nil {
+		return result, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return result, false
+	}
+	result.Sampled = flags[0]&1 == 1
+	result.TraceState = traceState
+	return result, result.IsValid()
+}
+
+// Span is a traced operation.
+type Span interface {
+	SpanContext() SpanContext
+	// SetAttributes adds attributes, as alternating keys and values, to the span.
+	SetAttributes(attrs ...interface{})
+	// RecordError marks the span as failed with err.
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts spans.
+type Tracer interface {
+	// Start starts a span named name, and returns it along with a context derived from ctx.
+	// The span is a child of SpanFromContext(ctx), if any, even if ctx has other spans of the
+	// tracer after it, and otherwise of RemoteSpanContext(ctx), if valid.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// SetTracer makes Handle trace requests using t, or stops tracing if t is nil.
+// Each request gets a span named after its route, with child spans for the filters,
+// the handler and the rendering of the response. The span of the handler is available
+// using SpanFromContext(r.Context()), and InjectTraceContext propagates it to other services.
+func SetTracer(t Tracer) {
+	tracer = t
+}
+
+// SpanFromContext returns the current span of ctx, or nil if there is none.
+func SpanFromContext(ctx context.Context) Span {
+	span, _ := ctx.Value(spanContextKey{}).(Span)
+	return span
+}
+
+// ContextWithSpan returns a context derived from ctx where span is the current span.
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// RemoteSpanContext returns the span context propagated by the client of the request of ctx, if any.
+func RemoteSpanContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(remoteSpanContextKey{}).(SpanContext)
+	return sc
+}
+
+// InjectTraceContext sets the traceparent and tracestate headers of header to propagate the
+// current span of ctx, if any, to another service.
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	sc := span.SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+	header.Set("traceparent", sc.TraceParent())
+	if sc.TraceState != "" {
+		header.Set("tracestate", sc.TraceState)
+	} else {
+		header.Del("tracestate")
+	}
+}
+
+// startTrace starts the span of a request to routeName, and returns the request using a context with
+// the span, and a function ending the span for the handler of the route to defer.
+func startTrace(w *statusWriter, httpR *http.Request, routeName string) (*http.Request, func()) {
+	t := tracer
+	if t == nil {
+		return httpR, func() {}
+	}
+	ctx := httpR.Context()
+	if remote, ok := ParseTraceParent(httpR.Header.Get("traceparent"), httpR.Header.Get("tracestate")); ok {
+		ctx = context.WithValue(ctx, remoteSpanContextKey{}, remote)
+	}
+	ctx, span := t.Start(ctx, routeName)
+	span.SetAttributes(
+		"http.request.method", httpR.Method,
+		"url.full", httpR.URL.String(),
+		"http.route", routeName)
+	return httpR.WithContext(ContextWithSpan(ctx, span)), func() {
+		status := w.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes("http.response.status_code", status)
+		if status >= 500 {
+			span.RecordError(fmt.Errorf("%d %s", status, http.StatusText(status)))
+		}
+		span.End()
+	}
+}
+
+// startSpan starts a child span of the current span of r, and makes it the current span of r
+// until the returned function ends it. The function records err, if any, in the span.
+// Contexts set using SetContext while the span runs are kept, with the parent span as current span.
+func (r *request) startSpan(name string, attrs ...interface{}) func(err error) {
+	t := tracer
+	if t == nil {
+		return func(error) {}
+	}
+	parent := r.ctx
+	parentSpan := SpanFromContext(parent)
+	if parentSpan == nil {
+		return func(error) {}
+	}
+	ctx, span := t.Start(parent, name)
+	span.SetAttributes(attrs...)
+	spanCtx := ContextWithSpan(ctx, span)
+	r.ctx = spanCtx
+	ended := false
+	end := func(err error) {
+		if ended {
+			return
+		}
+		ended = true
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+		if r.ctx == spanCtx {
+			r.ctx = parent
+		} else {
+			r.ctx = ContextWithSpan(r.ctx, parentSpan)
+		}
+	}
+	r.openSpans = append(r.openSpans, end)
+	return end
+}
+
+// endSpans ends the spans of r that are still open, like after a panic, recording err in them.
+func (r *request) endSpans(err error) {
+	for i := len(r.openSpans) - 1; i >= 0; i-- {
+		r.openSpans[i](err)
+	}
+	r.openSpans = nil
+}
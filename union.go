@@ -0,0 +1,147 @@
+package goaeoas
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Union describes an interface type whose values are one of several struct types,
+// told apart in JSON by the value of a discriminator field.
+type Union struct {
+	// Interface is the interface type.
+	Interface reflect.Type
+	// Discriminator is the JSON name of the field telling the variants apart. Each variant
+	// must have a string field with this name, which Copy sets when decoding.
+	Discriminator string
+	// Variants maps discriminator values to the types implementing Interface, either
+	// structs or pointers to structs.
+	Variants map[string]reflect.Type
+}
+
+var (
+	unions = map[reflect.Type]*Union{}
+)
+
+// RegisterUnion makes fields of type u.Interface decodable by Copy, and describes
+// them as unions in JSON Schemas and generated code.
+func RegisterUnion(u Union) {
+	if u.Interface == nil || u.Interface.Kind() != reflect.Interface {
+		panic(fmt.Errorf("%v isn't an interface type", u.Interface))
+	}
+	if u.Discriminator == "" || len(u.Variants) == 0 {
+		panic(fmt.Errorf("union %v needs a discriminator and variants", u.Interface))
+	}
+	for name, variant := range u.Variants {
+		if !variant.Implements(u.Interface) {
+			panic(fmt.Errorf("variant %q of %v, %v, doesn't implement it", name, u.Interface, variant))
+		}
+		structType := variant
+		if structType.Kind() == reflect.Ptr {
+			structType = structType.Elem()
+		}
+		if structType.Kind() != reflect.Struct {
+			panic(fmt.Errorf("variant %q of %v, %v, isn't a struct or a pointer to a struct", name, u.Interface, variant))
+		}
+		if _, found := discriminatorField(structType, u.Discriminator); !found {
+			panic(fmt.Errorf("variant %q of %v, %v, has no string field %q", name, u.Interface, variant, u.Discriminator))
+		}
+	}
+	unions[u.Interface] = &u
+	// Cached DocTypes and schemas of types containing the interface are no longer complete.
+	docTypes.Range(func(key, value interface{}) bool {
+		docTypes.Delete(key)
+		return true
+	})
+	jsonSchemas.Range(func(key, value interface{}) bool {
+		jsonSchemas.Delete(key)
+		return true
+	})
+}
+
+// discriminatorField returns the string field of structType named discriminator in JSON.
+func discriminatorField(structType reflect.Type, discriminator string) (*DocField, bool) {
+	fields, err := NewDocFields(structType, "")
+	if err != nil {
+		return nil, false
+	}
+	for _, field := range fields {
+		if field.Name == discriminator && field.field.Type.Kind() == reflect.String {
+			return &field, true
+		}
+	}
+	return nil, false
+}
+
+// sortedVariants returns the discriminator values of the variants of u, sorted.
+func (u *Union) sortedVariants() []string {
+	result := make([]string, 0, len(u.Variants))
+	for name := range u.Variants {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// unionOf returns the union, if any, that structType is a variant of.
+func unionOf(structType reflect.Type) *Union {
+	for _, u := range unions {
+		for _, variant := range u.Variants {
+			if variant == structType || (variant.Kind() == reflect.Ptr && variant.Elem() == structType) {
+				return u
+			}
+		}
+	}
+	return nil
+}
+
+// populateJavaUnion generates an abstract class for the interface of u, extended by the classes
+// of its variants, and a Gson deserializer picking the variant using the discriminator.
+func populateJavaUnion(javaClasses map[string]string, u *Union, pkg, meth string) error {
+	name := u.Interface.Name()
+	if _, found := javaClasses[name]; found {
+		return nil
+	}
+	javaClasses[name] = fmt.Sprintf(`package %s;
+
+public abstract class %s implements java.io.Serializable {
+}`, pkg, name)
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `package %s;
+
+import com.google.gson.*;
+import java.lang.reflect.Type;
+
+public class %sDeserializer implements JsonDeserializer<%s> {
+  public %s deserialize(JsonElement json, Type typeOfT, JsonDeserializationContext context) throws JsonParseException {
+    JsonElement discriminator = json.getAsJsonObject().get(%q);
+    if (discriminator == null) {
+      throw new JsonParseException("missing %s");
+    }
+    switch (discriminator.getAsString()) {
+`, pkg, name, name, name, u.Discriminator, u.Discriminator)
+	for _, variantName := range u.sortedVariants() {
+		variant := u.Variants[variantName]
+		if variant.Kind() == reflect.Ptr {
+			variant = variant.Elem()
+		}
+		fmt.Fprintf(buf, `    case %q:
+      return context.deserialize(json, %s.class);
+`, variantName, variant.Name())
+		dt, err := NewDocType(variant, meth)
+		if err != nil {
+			return err
+		}
+		if err := dt.populateJavaClasses(javaClasses, pkg, meth); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(buf, `    }
+    throw new JsonParseException("unknown %s " + discriminator.getAsString());
+  }
+}`, u.Discriminator)
+	javaClasses[name+"Deserializer"] = buf.String()
+	return nil
+}